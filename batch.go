@@ -0,0 +1,114 @@
+package tiny_bitcask
+
+import (
+	"tiny-bitcask/entity"
+)
+
+// WriteBatch collects Sets and Deletes that DB.Write commits atomically: after a
+// crash either every operation in the batch is visible or none is. A batch is
+// not safe for concurrent use and may be reused after Reset.
+type WriteBatch struct {
+	entries []*entity.Entry
+}
+
+// NewWriteBatch returns an empty batch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Set queues key = value. key and value are copied.
+func (b *WriteBatch) Set(key, value []byte) {
+	b.entries = append(b.entries, entity.NewEntryWithData(cloneBytes(key), cloneBytes(value)))
+}
+
+// Delete queues removal of key. Deleting a key that does not exist at commit
+// time is not an error.
+func (b *WriteBatch) Delete(key []byte) {
+	b.entries = append(b.entries, entity.NewTombstoneEntry(cloneBytes(key)))
+}
+
+// Len returns the number of queued operations.
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// Reset empties the batch for reuse.
+func (b *WriteBatch) Reset() {
+	b.entries = b.entries[:0]
+}
+
+// Write commits b atomically. Operations apply in the order they were queued,
+// so a later Set or Delete of the same key wins. The batch is written to the
+// active segment in one write framed by header and commit records; recovery,
+// hint generation and merge ignore a batch whose commit record is missing.
+func (db *DB) Write(b *WriteBatch) error {
+	if err := checkEntryKeys(b.entries); err != nil {
+		return err
+	}
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	return db.writeEntries(b.entries)
+}
+
+// writeEntries commits entries as one batch and applies them to the keydir and
+// secondary indexes in order. Caller holds db.rw for writing.
+func (db *DB) writeEntries(entries []*entity.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := db.checkBatchKeydirLimit(entries); err != nil {
+		return err
+	}
+	hs, err := db.storage.WriteBatch(entries)
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		switch {
+		case e.Meta.Flag != entity.DeleteFlag:
+			err = db.applyPut(hs[i], e)
+		case db.find(string(e.Key)) == nil:
+			// Like Delete, a missing key is neither counted nor reported; an
+			// expired entry the sweeper has not dropped yet still goes.
+			err = db.dropKey(e.Key, db.tombstoneAt(hs[i], e))
+		default:
+			db.counters.deletes.Add(1)
+			err = db.applyDelete(e.Key, OpDelete, db.tombstoneAt(hs[i], e))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBatchKeydirLimit is checkKeydirLimit for all keys a batch would add.
+func (db *DB) checkBatchKeydirLimit(entries []*entity.Entry) error {
+	if db.opt.MaxKeydirBytes <= 0 || db.opt.KeydirOnDisk {
+		return nil
+	}
+	var grow int64
+	seen := map[string]struct{}{}
+	for _, e := range entries {
+		k := string(e.Key)
+		if e.Meta.Flag == entity.DeleteFlag {
+			continue
+		}
+		if _, dup := seen[k]; dup {
+			// A repeated key overwrites the batch's own earlier put.
+			if db.hist != nil {
+				grow += versionBytes
+			}
+			continue
+		}
+		seen[k] = struct{}{}
+		grow += db.keydirGrowth(k)
+	}
+	if grow > 0 && db.keydirBytes()+grow > db.opt.MaxKeydirBytes {
+		return KeydirFullErr
+	}
+	return nil
+}
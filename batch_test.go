@@ -0,0 +1,129 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/entity"
+	"tiny-bitcask/storage"
+)
+
+func TestWriteBatch_Apply(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.Indexes = map[string]IndexFunc{"email": emailIndex}
+	})
+	require.NoError(t, db.Set([]byte("gone"), []byte("x")))
+
+	b := NewWriteBatch()
+	b.Set([]byte("a"), []byte("1"))
+	b.Set([]byte("u1"), []byte("Ann|ann@example.com"))
+	b.Set([]byte("a"), []byte("2"))
+	b.Delete([]byte("gone"))
+	b.Delete([]byte("never-existed"))
+	assert.Equal(t, 5, b.Len())
+	require.NoError(t, db.Write(b))
+
+	got, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(got))
+	_, err = db.Get([]byte("gone"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+	keys, err := db.LookupIndex("email", []byte("ann@example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, keyStrings(keys))
+
+	b.Reset()
+	assert.Equal(t, 0, b.Len())
+	require.NoError(t, db.Write(b))
+
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	db, err = NewDB(&Options{Dir: dir, VerifyCRC: true})
+	require.NoError(t, err)
+	defer db.Close()
+	got, err = db.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(got))
+	_, err = db.Get([]byte("gone"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+}
+
+// TestWriteBatch_TornBatchIgnored simulates a crash mid-batch: a header and
+// only some of its records reach the segment. Recovery must drop them and
+// truncate the tail so writes after reopen survive the next reopen.
+func TestWriteBatch_TornBatchIgnored(t *testing.T) {
+	db := newTestDB(t, nil)
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+
+	path := storage.DataFilePath(dir, 1)
+	st, err := os.Stat(path)
+	require.NoError(t, err)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write(entity.NewBatchMarker(entity.BatchFlag, 2).Encode())
+	require.NoError(t, err)
+	_, err = f.Write(entity.NewEntryWithData([]byte("a"), []byte("torn")).Encode())
+	require.NoError(t, err)
+	partial := entity.NewEntryWithData([]byte("b"), []byte("torn")).Encode()
+	_, err = f.Write(partial[:len(partial)/2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	opt := &Options{Dir: dir, VerifyCRC: true}
+	db, err = NewDB(opt)
+	require.NoError(t, err)
+	got, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(got))
+	_, err = db.Get([]byte("b"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+	assert.Equal(t, st.Size(), db.storage.ActiveOffset())
+
+	require.NoError(t, db.Set([]byte("c"), []byte("3")))
+	require.NoError(t, db.Close())
+	db, err = NewDB(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	got, err = db.Get([]byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(got))
+}
+
+// TestWriteBatch_HintAndMerge checks committed batches survive rotation (hint
+// generation) and merge, which both read segments through the batch scanner.
+func TestWriteBatch_HintAndMerge(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	for i := 0; i < 40; i++ {
+		b := NewWriteBatch()
+		for j := 0; j < 5; j++ {
+			b.Set([]byte(fmt.Sprintf("k%02d-%d", i%10, j)), []byte(fmt.Sprintf("v%d-%070d", i, j)))
+		}
+		require.NoError(t, db.Write(b))
+	}
+	require.Greater(t, len(db.storage.GetOldFiles()), 2)
+	require.NoError(t, db.Merge())
+
+	check := func(db *DB) {
+		for i := 30; i < 40; i++ {
+			for j := 0; j < 5; j++ {
+				got, err := db.Get([]byte(fmt.Sprintf("k%02d-%d", i%10, j)))
+				require.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("v%d-%070d", i, j), string(got))
+			}
+		}
+	}
+	check(db)
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	db, err := NewDB(&Options{Dir: dir, SegmentSize: 4 * storage.KB, VerifyCRC: true})
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
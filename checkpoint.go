@@ -0,0 +1,111 @@
+package tiny_bitcask
+
+import (
+	"time"
+
+	"tiny-bitcask/index"
+	"tiny-bitcask/storage"
+)
+
+// Checkpoint writes the whole keydir and the active segment position it covers
+// to keydir.ckpt, so the next open only replays data appended after it. Only
+// the sync and the copy of the keydir rows happen under the read lock; rows
+// are encoded and written after it is released. A merge running meanwhile can
+// leave the file pointing at removed segments, which the next open rejects.
+func (db *DB) Checkpoint() error {
+	db.ckptMu.Lock()
+	defer db.ckptMu.Unlock()
+	db.rw.RLock()
+	if db.opt.ReadOnly {
+		db.rw.RUnlock()
+		return ReadOnlyDBErr
+	}
+	if db.storage == nil {
+		db.rw.RUnlock()
+		return nil
+	}
+	cp, err := db.checkpointImage()
+	db.rw.RUnlock()
+	if err != nil {
+		return err
+	}
+	return storage.WriteCheckpoint(db.opt.Dir, cp)
+}
+
+// checkpointImage syncs the active segment, so every byte the checkpoint
+// covers is durable before (Fid, Off) is recorded, and copies the keydir rows.
+// Sealed segments were synced on rotation. Caller holds db.rw.
+func (db *DB) checkpointImage() (*storage.Checkpoint, error) {
+	if err := db.storage.Sync(); err != nil {
+		return nil, err
+	}
+	cp := &storage.Checkpoint{
+		Fid:     db.storage.ActiveFid(),
+		Off:     db.storage.ActiveOffset(),
+		Seq:     db.storage.Seq(),
+		Records: make([]storage.CheckpointRecord, 0, db.kd.Len()),
+	}
+	db.kd.Range(func(key string, dp *index.DataPosition) bool {
+		cp.Records = append(cp.Records, storage.CheckpointRecord{
+			Fid:       dp.Fid,
+			Off:       dp.Off,
+			Timestamp: dp.Timestamp,
+			KeySize:   uint32(dp.KeySize),
+			ValueSize: uint32(dp.ValueSize),
+			Expiry:    dp.Expiry,
+			Seq:       dp.Seq,
+			Key:       []byte(key),
+		})
+		return true
+	})
+	return cp, nil
+}
+
+// loadCheckpoint fills the keydir from keydir.ckpt when it is consistent with
+// the segments in fids. ok is false (and the keydir untouched) otherwise.
+func (db *DB) loadCheckpoint(dir string, fids []int) (cp *storage.Checkpoint, ok bool) {
+	cp, err := storage.ReadCheckpoint(dir)
+	if err != nil {
+		return nil, false
+	}
+	sizes, err := segmentSizes(dir, fids)
+	if err != nil {
+		return nil, false
+	}
+	if size, exist := sizes[cp.Fid]; !exist || cp.Off < 0 || cp.Off > size {
+		return nil, false
+	}
+	for _, r := range cp.Records {
+		size, exist := sizes[r.Fid]
+		recLen := db.storage.RecordLen(r.Fid, int(r.KeySize), int(r.ValueSize))
+		if !exist || r.Off < 0 || r.Off+recLen > size {
+			return nil, false
+		}
+		if r.Fid > cp.Fid || (r.Fid == cp.Fid && r.Off+recLen > cp.Off) {
+			return nil, false
+		}
+	}
+	now := time.Now().UnixNano()
+	for _, r := range cp.Records {
+		if r.Expiry != 0 && r.Expiry <= uint64(now) {
+			continue
+		}
+		index.AddIndexBySizes(db.kd, r.Fid, r.Off, r.Key, int(r.KeySize), int(r.ValueSize), r.Timestamp, r.Expiry, r.Seq)
+	}
+	return cp, true
+}
+
+// checkpointLoop writes a checkpoint every interval until Close.
+func (db *DB) checkpointLoop(interval time.Duration) {
+	defer db.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-db.stopc:
+			return
+		case <-t.C:
+			_ = db.Checkpoint()
+		}
+	}
+}
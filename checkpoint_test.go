@@ -0,0 +1,163 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/storage"
+)
+
+// TestDB_Checkpoint_ReplaysOnlyNewData checks that recovery starts from the
+// checkpoint and still applies writes and deletes made after it.
+func TestDB_Checkpoint_ReplaysOnlyNewData(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "ckptdb")
+	opt := *DefaultOptions
+	opt.Dir = dataDir
+	opt.SegmentSize = 4 * storage.KB
+
+	db1, err := NewDB(&opt)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, db1.Set([]byte(fmt.Sprintf("k_%d", i)), []byte(fmt.Sprintf("v_%d", i))))
+	}
+	require.NoError(t, db1.Checkpoint())
+
+	require.NoError(t, db1.Set([]byte("k_0"), []byte("after")))
+	require.NoError(t, db1.Delete([]byte("k_1")))
+	require.NoError(t, db1.Set([]byte("new"), []byte("n")))
+	require.NoError(t, db1.Close())
+
+	db2, err := NewDB(&opt)
+	require.NoError(t, err)
+	defer db2.Close()
+
+	got, err := db2.Get([]byte("k_0"))
+	require.NoError(t, err)
+	assert.Equal(t, "after", string(got))
+	_, err = db2.Get([]byte("k_1"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+	got, err = db2.Get([]byte("k_299"))
+	require.NoError(t, err)
+	assert.Equal(t, "v_299", string(got))
+	got, err = db2.Get([]byte("new"))
+	require.NoError(t, err)
+	assert.Equal(t, "n", string(got))
+	assert.Len(t, db2.ListKeys(), 300)
+}
+
+func TestDB_Checkpoint_WrittenOnClose(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "ckptclose")
+	opt := *DefaultOptions
+	opt.Dir = dataDir
+	opt.CheckpointInterval = time.Hour
+
+	db1, err := NewDB(&opt)
+	require.NoError(t, err)
+	require.NoError(t, db1.Set([]byte("k"), []byte("v")))
+	require.NoError(t, db1.Close())
+
+	_, err = os.Stat(storage.CheckpointPath(dataDir))
+	require.NoError(t, err)
+
+	db2, err := NewDB(&opt)
+	require.NoError(t, err)
+	defer db2.Close()
+	got, err := db2.Get([]byte("k"))
+	require.NoError(t, err)
+	assert.Equal(t, "v", string(got))
+}
+
+func TestDB_Checkpoint_InvalidFallsBackToScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, dir string)
+	}{
+		{
+			name: "corrupt_file",
+			corrupt: func(t *testing.T, dir string) {
+				require.NoError(t, os.WriteFile(storage.CheckpointPath(dir), []byte("TBCK garbage"), 0o644))
+			},
+		},
+		{
+			name: "references_missing_segment",
+			corrupt: func(t *testing.T, dir string) {
+				cp := &storage.Checkpoint{Fid: 99, Off: 0}
+				require.NoError(t, storage.WriteCheckpoint(dir, cp))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir := filepath.Join(t.TempDir(), "ckptbad")
+			opt := *DefaultOptions
+			opt.Dir = dataDir
+
+			db1, err := NewDB(&opt)
+			require.NoError(t, err)
+			require.NoError(t, db1.Set([]byte("k"), []byte("v")))
+			require.NoError(t, db1.Close())
+			tt.corrupt(t, dataDir)
+
+			db2, err := NewDB(&opt)
+			require.NoError(t, err)
+			defer db2.Close()
+			got, err := db2.Get([]byte("k"))
+			require.NoError(t, err)
+			assert.Equal(t, "v", string(got))
+		})
+	}
+}
+
+func TestDB_Merge_RemovesCheckpoint(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, db.Set([]byte("busy"), []byte(fmt.Sprintf("v_%d", i))))
+	}
+	require.NoError(t, db.Checkpoint())
+	require.NoError(t, db.Merge())
+
+	_, err := os.Stat(storage.CheckpointPath(db.opt.Dir))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestDB_Checkpoint_ConcurrentWrites checks checkpoints taken while writers
+// run stay consistent: every key written before Close is there after reopen.
+func TestDB_Checkpoint_ConcurrentWrites(t *testing.T) {
+	opt := *DefaultOptions
+	opt.Dir = filepath.Join(t.TempDir(), "ckptconc")
+	opt.SegmentSize = 4 * storage.KB
+	db, err := NewDB(&opt)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 500; i++ {
+			if err := db.Set([]byte(fmt.Sprintf("k_%d", i)), []byte(fmt.Sprintf("v_%d", i))); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Checkpoint())
+	}
+	require.NoError(t, <-done)
+	require.NoError(t, db.Close())
+
+	db, err = NewDB(&opt)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Len(t, db.ListKeys(), 500)
+	got, err := db.Get([]byte("k_499"))
+	require.NoError(t, err)
+	assert.Equal(t, "v_499", string(got))
+}
@@ -0,0 +1,89 @@
+package tiny_bitcask
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec converts a Collection's keys or values to and from bytes.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec encodes T with encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec encodes T with encoding/gob. Each value carries its own type
+// description, so gob suits values better than keys.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// BinaryCodec encodes fixed-size T (numbers, and arrays and structs of them)
+// with encoding/binary in big-endian order, so unsigned integer keys iterate
+// in numeric order.
+type BinaryCodec[T any] struct{}
+
+func (BinaryCodec[T]) Encode(v T) ([]byte, error) {
+	return binary.Append(nil, binary.BigEndian, v)
+}
+
+func (BinaryCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	n, err := binary.Decode(data, binary.BigEndian, &v)
+	if err != nil {
+		return v, err
+	}
+	if n != len(data) {
+		return v, fmt.Errorf("binary codec: %d trailing bytes", len(data)-n)
+	}
+	return v, nil
+}
+
+// RawCodec stores byte slices as they are.
+type RawCodec struct{}
+
+func (RawCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (RawCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// StringCodec stores strings as their bytes.
+type StringCodec struct{}
+
+func (StringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (StringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
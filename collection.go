@@ -0,0 +1,138 @@
+package tiny_bitcask
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"strings"
+)
+
+var (
+	InvalidCollectionNameErr = errors.New("collection name must be non-empty and contain no NUL byte")
+)
+
+// collectionKeyPrefix starts the key of every Collection record. It is
+// reserved: plain writes of keys under it fail with ReservedKeyErr.
+const collectionKeyPrefix = "\x00col\x00"
+
+// Collection is a typed view of the keys of one named namespace in a DB. Keys
+// are stored as collectionKeyPrefix, the name, a NUL byte and the encoded key,
+// so collections never see each other's keys or plain DB keys.
+type Collection[K, V any] struct {
+	db     *DB
+	prefix []byte
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// Pair is one key/value pair yielded by Collection.Entries.
+type Pair[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// NewCollection returns the collection called name in db, encoding keys with
+// kc and values with vc. Opening the same name again with the same codecs
+// gives access to the same data.
+func NewCollection[K, V any](db *DB, name string, kc Codec[K], vc Codec[V]) (*Collection[K, V], error) {
+	if name == "" || strings.IndexByte(name, 0) >= 0 {
+		return nil, InvalidCollectionNameErr
+	}
+	return &Collection[K, V]{
+		db:     db,
+		prefix: []byte(collectionKeyPrefix + name + "\x00"),
+		keys:   kc,
+		values: vc,
+	}, nil
+}
+
+func (c *Collection[K, V]) dbKey(k K) ([]byte, error) {
+	enc, err := c.keys.Encode(k)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make([]byte, 0, len(c.prefix)+len(enc)), c.prefix...), enc...), nil
+}
+
+// Put stores v under k.
+func (c *Collection[K, V]) Put(k K, v V) error {
+	key, err := c.dbKey(k)
+	if err != nil {
+		return err
+	}
+	value, err := c.values.Encode(v)
+	if err != nil {
+		return err
+	}
+	return c.db.setContext(context.Background(), key, value)
+}
+
+// Get returns the value stored under k, or KeyNotFoundErr.
+func (c *Collection[K, V]) Get(k K) (V, error) {
+	var zero V
+	key, err := c.dbKey(k)
+	if err != nil {
+		return zero, err
+	}
+	value, err := c.db.Get(key)
+	if err != nil {
+		return zero, err
+	}
+	return c.values.Decode(value)
+}
+
+// Delete removes k, or returns KeyNotFoundErr.
+func (c *Collection[K, V]) Delete(k K) error {
+	key, err := c.dbKey(k)
+	if err != nil {
+		return err
+	}
+	return c.db.deleteContext(context.Background(), key)
+}
+
+// All yields every pair in the order of the encoded keys, with the semantics of
+// DB.Scan. Iteration stops at the first read or decode error; use Entries to
+// observe it.
+func (c *Collection[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for p, err := range c.Entries() {
+			if err != nil || !yield(p.Key, p.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Entries is the error-reporting form of All: a failed read or decode yields
+// the error as the final element.
+func (c *Collection[K, V]) Entries() iter.Seq2[Pair[K, V], error] {
+	return func(yield func(Pair[K, V], error) bool) {
+		for kv, err := range c.db.entries(c.prefix, prefixEnd(c.prefix)) {
+			if err != nil {
+				yield(Pair[K, V]{}, err)
+				return
+			}
+			p, err := c.decode(kv)
+			if err != nil {
+				yield(Pair[K, V]{}, err)
+				return
+			}
+			if !yield(p, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (c *Collection[K, V]) decode(kv KV) (p Pair[K, V], err error) {
+	if p.Key, err = c.keys.Decode(kv.Key[len(c.prefix):]); err != nil {
+		return p, err
+	}
+	p.Value, err = c.values.Decode(kv.Value)
+	return p, err
+}
+
+// DeleteAll removes every key of the collection with one range tombstone.
+func (c *Collection[K, V]) DeleteAll() error {
+	return c.db.deleteRanges([]keyRange{{c.prefix, prefixEnd(c.prefix)}})
+}
@@ -0,0 +1,90 @@
+package tiny_bitcask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testUser struct {
+	Name  string
+	Email string
+}
+
+func TestCollection_PutGetDelete(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	users, err := NewCollection(db, "users", StringCodec{}, JSONCodec[testUser]{})
+	require.NoError(t, err)
+
+	require.NoError(t, users.Put("ann", testUser{Name: "Ann", Email: "ann@example.com"}))
+	u, err := users.Get("ann")
+	require.NoError(t, err)
+	assert.Equal(t, testUser{Name: "Ann", Email: "ann@example.com"}, u)
+
+	require.NoError(t, users.Delete("ann"))
+	_, err = users.Get("ann")
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+	assert.ErrorIs(t, users.Delete("ann"), KeyNotFoundErr)
+
+	_, err = NewCollection(db, "", StringCodec{}, RawCodec{})
+	assert.ErrorIs(t, err, InvalidCollectionNameErr)
+	_, err = NewCollection(db, "a\x00b", StringCodec{}, RawCodec{})
+	assert.ErrorIs(t, err, InvalidCollectionNameErr)
+}
+
+func TestCollection_IsolationAndOrder(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	seqs, err := NewCollection(db, "seq", BinaryCodec[uint64]{}, GobCodec[[]string]{})
+	require.NoError(t, err)
+	other, err := NewCollection(db, "seq2", BinaryCodec[uint64]{}, RawCodec{})
+	require.NoError(t, err)
+
+	for _, n := range []uint64{300, 2, 1 << 40} {
+		require.NoError(t, seqs.Put(n, []string{"v", "w"}))
+	}
+	require.NoError(t, other.Put(7, []byte("x")))
+	require.NoError(t, db.Set([]byte("plain"), []byte("y")))
+
+	var got []uint64
+	for k, v := range seqs.All() {
+		got = append(got, k)
+		assert.Equal(t, []string{"v", "w"}, v)
+	}
+	assert.Equal(t, []uint64{2, 300, 1 << 40}, got)
+
+	require.NoError(t, seqs.DeleteAll())
+	for range seqs.All() {
+		t.Fatal("collection should be empty")
+	}
+	v, err := other.Get(7)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), v)
+	_, err = db.Get([]byte("plain"))
+	assert.NoError(t, err)
+}
+
+func TestCollection_DecodeError(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	nums, err := NewCollection(db, "nums", StringCodec{}, BinaryCodec[int32]{})
+	require.NoError(t, err)
+	raw, err := NewCollection(db, "nums", StringCodec{}, RawCodec{})
+	require.NoError(t, err)
+	require.NoError(t, nums.Put("a", 1))
+	require.NoError(t, raw.Put("b", []byte("too long")))
+
+	_, err = nums.Get("b")
+	assert.Error(t, err)
+	var errs []error
+	for p, err := range nums.Entries() {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		assert.Equal(t, Pair[string, int32]{Key: "a", Value: 1}, p)
+	}
+	assert.Len(t, errs, 1)
+}
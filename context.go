@@ -0,0 +1,175 @@
+package tiny_bitcask
+
+import (
+	"context"
+	"fmt"
+)
+
+// ctxErr wraps a context error with the operation it interrupted.
+func ctxErr(op string, err error) error {
+	return fmt.Errorf("tiny-bitcask: %s: %w", op, err)
+}
+
+// lockContext acquires a lock via lock, giving up when ctx is done first. A
+// lock acquired after the caller gave up is released straight away, so an
+// abandoned wait only delays other lockers briefly. Exactly one side releases
+// it: the caller on a nil return, the waiting goroutine otherwise, even when
+// the lock and ctx.Done arrive together.
+func lockContext(ctx context.Context, op string, tryLock func() bool, lock, unlock func()) error {
+	if ctx.Done() == nil {
+		lock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return ctxErr(op, err)
+	}
+	if tryLock() {
+		return nil
+	}
+	acquired := make(chan struct{})
+	go func() {
+		lock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			unlock()
+		}()
+		return ctxErr(op, ctx.Err())
+	}
+}
+
+func (db *DB) lockCtx(ctx context.Context, op string) error {
+	return lockContext(ctx, op, db.rw.TryLock, db.rw.Lock, db.rw.Unlock)
+}
+
+func (db *DB) rlockCtx(ctx context.Context, op string) error {
+	return lockContext(ctx, op, db.rw.TryRLock, db.rw.RLock, db.rw.RUnlock)
+}
+
+// GetContext is Get that stops waiting for the DB lock when ctx is done.
+func (db *DB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	if err := db.rlockCtx(ctx, "get"); err != nil {
+		return nil, err
+	}
+	defer db.rw.RUnlock()
+	db.counters.gets.Add(1)
+	i := db.find(string(key))
+	if i == nil {
+		return nil, KeyNotFoundErr
+	}
+	value, release, err := db.storage.ReadValue(i)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return append(make([]byte, 0, len(value)), value...), nil
+}
+
+// SetContext is Set that stops waiting for the DB lock when ctx is done.
+func (db *DB) SetContext(ctx context.Context, key, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return db.setContext(ctx, key, value)
+}
+
+// setContext is SetContext without the reserved prefix check.
+func (db *DB) setContext(ctx context.Context, key, value []byte) error {
+	if err := db.lockCtx(ctx, "set"); err != nil {
+		return err
+	}
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	_, err := db.put(key, value)
+	return err
+}
+
+// DeleteContext is Delete that stops waiting for the DB lock when ctx is done.
+func (db *DB) DeleteContext(ctx context.Context, key []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return db.deleteContext(ctx, key)
+}
+
+// deleteContext is DeleteContext without the reserved prefix check.
+func (db *DB) deleteContext(ctx context.Context, key []byte) error {
+	if err := db.lockCtx(ctx, "delete"); err != nil {
+		return err
+	}
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	if db.find(string(key)) == nil {
+		return KeyNotFoundErr
+	}
+	return db.remove(key)
+}
+
+// FoldContext is Fold that also stops between records once ctx is done.
+func (db *DB) FoldContext(ctx context.Context, fn func(key, value []byte) error) error {
+	if err := db.rlockCtx(ctx, "fold"); err != nil {
+		return err
+	}
+	defer db.rw.RUnlock()
+	return db.eachKey(func(k string) error {
+		if err := ctx.Err(); err != nil {
+			return ctxErr("fold", err)
+		}
+		dp := db.find(k)
+		if dp == nil {
+			return nil
+		}
+		entry, err := db.storage.ReadEntry(dp)
+		if err != nil {
+			return err
+		}
+		return fn([]byte(k), entry.Value)
+	})
+}
+
+// MergeContext is Merge that stops waiting for the DB lock, and stops between
+// segments, once ctx is done. Segments merged before that stay merged.
+func (db *DB) MergeContext(ctx context.Context) error {
+	if err := db.lockCtx(ctx, "merge"); err != nil {
+		return err
+	}
+	defer db.rw.Unlock()
+	return db.merge(ctx)
+}
+
+// SyncContext is Sync that stops waiting for the DB lock, or for the fsync to
+// finish, once ctx is done. An fsync already started cannot be interrupted; it
+// completes in the background and releases the lock afterwards.
+func (db *DB) SyncContext(ctx context.Context) error {
+	if err := db.lockCtx(ctx, "sync"); err != nil {
+		return err
+	}
+	if db.storage == nil {
+		db.rw.Unlock()
+		return nil
+	}
+	if ctx.Done() == nil {
+		defer db.rw.Unlock()
+		return db.storage.Sync()
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer db.rw.Unlock()
+		done <- db.storage.Sync()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctxErr("sync", ctx.Err())
+	}
+}
@@ -0,0 +1,127 @@
+package tiny_bitcask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContext_CancelledBeforeStart(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("k"), []byte("v")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := db.GetContext(ctx, []byte("k"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, err.Error(), "get")
+	assert.ErrorIs(t, db.SetContext(ctx, []byte("k"), []byte("w")), context.Canceled)
+	assert.ErrorIs(t, db.DeleteContext(ctx, []byte("k")), context.Canceled)
+	assert.ErrorIs(t, db.SyncContext(ctx), context.Canceled)
+	assert.ErrorIs(t, db.MergeContext(ctx), context.Canceled)
+
+	v, err := db.Get([]byte("k"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), v)
+}
+
+func TestContext_GivesUpWaitingForLock(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("k"), []byte("v")))
+
+	db.rw.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := db.GetContext(ctx, []byte("k"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	err = db.SetContext(ctx, []byte("k"), []byte("w"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	db.rw.Unlock()
+
+	// The abandoned waits release the lock once they get it.
+	done := make(chan error, 1)
+	go func() { done <- db.Set([]byte("k"), []byte("x")) }()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("lock not released after abandoned waits")
+	}
+	v, err := db.GetContext(context.Background(), []byte("k"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), v)
+}
+
+// TestContext_CancelRacesLock cancels waits just as the lock frees up; every
+// lock taken must be released and cancelled gets must not be counted.
+func TestContext_CancelRacesLock(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("k"), []byte("v")))
+
+	var counted uint64
+	for i := 0; i < 200; i++ {
+		db.rw.Lock()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			_, err := db.GetContext(ctx, []byte("k"))
+			done <- err
+		}()
+		time.Sleep(10 * time.Microsecond)
+		go cancel()
+		db.rw.Unlock()
+		if err := <-done; err == nil {
+			counted++
+		} else {
+			require.ErrorIs(t, err, context.Canceled)
+		}
+	}
+	locked := make(chan struct{})
+	go func() {
+		db.rw.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		db.rw.Unlock()
+	case <-time.After(time.Second):
+		t.Fatal("a read lock leaked")
+	}
+	assert.Equal(t, counted, db.Stats().Gets)
+}
+
+func TestContext_FoldStopsBetweenRecords(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte("v")))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seen := 0
+	err := db.FoldContext(ctx, func(key, value []byte) error {
+		seen++
+		if seen == 3 {
+			cancel()
+		}
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 3, seen)
+
+	seen = 0
+	require.NoError(t, db.FoldContext(context.Background(), func(key, value []byte) error {
+		seen++
+		return nil
+	}))
+	assert.Equal(t, 10, seen)
+}
@@ -0,0 +1,96 @@
+package tiny_bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+)
+
+var (
+	NotCounterErr      = errors.New("value is not a counter")
+	CounterOverflowErr = errors.New("counter overflow")
+)
+
+// counterSize is the length of a counter value: an int64, little-endian.
+const counterSize = 8
+
+// IncrBy adds delta to the counter at key and returns the new value. A missing
+// key counts from 0. The read and the write happen under one write lock, so
+// concurrent increments never lose updates. A key holding anything but a
+// counter record (one IncrBy/DecrBy wrote, marked with entity.CounterFlag)
+// fails with NotCounterErr, even an 8-byte value set with Set; a result outside
+// int64 fails with CounterOverflowErr. The key's TTL, if any, is kept.
+func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return 0, ReadOnlyDBErr
+	}
+	var n int64
+	var expiry uint64
+	if dp := db.find(string(key)); dp != nil {
+		cur, err := db.readCounter(dp)
+		if err != nil {
+			return 0, err
+		}
+		n, expiry = cur, dp.Expiry
+	}
+	sum := n + delta
+	if (delta > 0 && sum < n) || (delta < 0 && sum > n) {
+		return 0, CounterOverflowErr
+	}
+	entry := entity.NewEntryWithData(key, encodeCounter(sum))
+	entry.Meta.WithFlag(entity.CounterFlag).WithExpiry(expiry)
+	if _, err := db.putEntry(entry); err != nil {
+		return 0, err
+	}
+	return sum, nil
+}
+
+// DecrBy subtracts delta from the counter at key; see IncrBy.
+func (db *DB) DecrBy(key []byte, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, CounterOverflowErr
+	}
+	return db.IncrBy(key, -delta)
+}
+
+// Counter returns the counter at key without changing it.
+func (db *DB) Counter(key []byte) (int64, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	dp := db.find(string(key))
+	if dp == nil {
+		return 0, KeyNotFoundErr
+	}
+	return db.readCounter(dp)
+}
+
+// readCounter decodes the counter a keydir entry points at, which must be a
+// counter record. Caller holds db.rw.
+func (db *DB) readCounter(dp *index.DataPosition) (int64, error) {
+	if dp.ValueSize != counterSize {
+		return 0, NotCounterErr
+	}
+	entry, err := db.storage.ReadEntry(dp)
+	if err != nil {
+		return 0, err
+	}
+	if entry.Meta.Flag != entity.CounterFlag {
+		return 0, NotCounterErr
+	}
+	return int64(binary.LittleEndian.Uint64(entry.Value)), nil
+}
+
+func encodeCounter(n int64) []byte {
+	buf := make([]byte, counterSize)
+	binary.LittleEndian.PutUint64(buf, uint64(n))
+	return buf
+}
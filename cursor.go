@@ -0,0 +1,188 @@
+package tiny_bitcask
+
+import "tiny-bitcask/index"
+
+// CursorOptions configures NewCursor.
+type CursorOptions struct {
+	Prefix   []byte // only visit keys with this prefix
+	Reverse  bool   // walk keys in descending order
+	KeysOnly bool   // do not read values; Value returns nil
+}
+
+// Cursor walks keys in sorted order with random repositioning.
+//
+// The key set is a snapshot taken by NewCursor (or Refresh): keys added later
+// are not visited, nor are the reserved keys of collections and data
+// structures. Over an on-disk keydir a large key set spills to a scratch
+// file in Options.Dir until Close. Values are read-committed: each positioning reads the value
+// current at that moment, and a snapshot key deleted since is skipped in the
+// direction of travel. No lock is held between calls, so a Cursor never blocks
+// writers. A Cursor is not safe for concurrent use.
+type Cursor struct {
+	db   *DB
+	opt  CursorOptions
+	keys *index.Run // ascending
+	pos  int
+	key  []byte
+	val  []byte
+	err  error
+}
+
+// NewCursor returns an unpositioned cursor; call First, Last or Seek before
+// reading. opts may be nil.
+func (db *DB) NewCursor(opts *CursorOptions) *Cursor {
+	c := &Cursor{db: db}
+	if opts != nil {
+		c.opt = *opts
+	}
+	c.Refresh()
+	return c
+}
+
+// Refresh retakes the key snapshot and unpositions the cursor. Failing to take
+// it leaves the cursor empty with Err set.
+func (c *Cursor) Refresh() {
+	var end []byte
+	if len(c.opt.Prefix) > 0 {
+		end = prefixEnd(c.opt.Prefix)
+	}
+	c.Close()
+	c.keys, c.err = c.db.newRun(c.opt.Prefix, end, false)
+	if c.err != nil {
+		c.keys = &index.Run{}
+	}
+}
+
+// First moves to the first key in cursor order (the largest when Reverse).
+func (c *Cursor) First() bool {
+	if c.opt.Reverse {
+		return c.settle(c.keys.Len()-1, -1)
+	}
+	return c.settle(0, 1)
+}
+
+// Last moves to the last key in cursor order (the smallest when Reverse).
+func (c *Cursor) Last() bool {
+	if c.opt.Reverse {
+		return c.settle(0, 1)
+	}
+	return c.settle(c.keys.Len()-1, -1)
+}
+
+// Seek moves to the first key at or after key in cursor order: the smallest
+// key >= key, or with Reverse the largest key <= key.
+func (c *Cursor) Seek(key []byte) bool {
+	i, err := c.keys.Search(string(key))
+	if err != nil {
+		c.key, c.val, c.err = nil, nil, err
+		return false
+	}
+	if !c.opt.Reverse {
+		return c.settle(i, 1)
+	}
+	if i < c.keys.Len() {
+		k, _, err := c.keys.At(i)
+		if err != nil {
+			c.key, c.val, c.err = nil, nil, err
+			return false
+		}
+		if k != string(key) {
+			i--
+		}
+	} else {
+		i--
+	}
+	return c.settle(i, -1)
+}
+
+// Next advances one key in cursor order.
+func (c *Cursor) Next() bool {
+	if !c.Valid() {
+		return false
+	}
+	step := c.step()
+	return c.settle(c.pos+step, step)
+}
+
+// Prev moves back one key in cursor order.
+func (c *Cursor) Prev() bool {
+	if !c.Valid() {
+		return false
+	}
+	step := -c.step()
+	return c.settle(c.pos+step, step)
+}
+
+// Valid reports whether the cursor is positioned on a key.
+func (c *Cursor) Valid() bool {
+	return c.err == nil && c.pos >= 0 && c.pos < c.keys.Len()
+}
+
+// Key returns the current key, or nil when not Valid.
+func (c *Cursor) Key() []byte {
+	return c.key
+}
+
+// Value returns the current value, or nil when not Valid or KeysOnly.
+func (c *Cursor) Value() []byte {
+	return c.val
+}
+
+// Err returns the read error that invalidated the cursor, if any.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close releases the key snapshot.
+func (c *Cursor) Close() {
+	if c.keys != nil {
+		c.keys.Close()
+	}
+	c.keys = &index.Run{}
+	c.pos = -1
+	c.key, c.val = nil, nil
+}
+
+func (c *Cursor) step() int {
+	if c.opt.Reverse {
+		return -1
+	}
+	return 1
+}
+
+// settle positions the cursor at keys[i], moving by step past keys that no
+// longer exist.
+func (c *Cursor) settle(i, step int) bool {
+	c.key, c.val, c.err = nil, nil, nil
+	for ; i >= 0 && i < c.keys.Len(); i += step {
+		key, _, err := c.keys.At(i)
+		if err != nil {
+			c.pos, c.err = i, err
+			return false
+		}
+		k := []byte(key)
+		if c.opt.KeysOnly {
+			if !c.db.Has(k) {
+				continue
+			}
+		} else {
+			v, err := c.db.Get(k)
+			if err == KeyNotFoundErr {
+				continue
+			}
+			if err != nil {
+				c.pos, c.err = i, err
+				return false
+			}
+			c.val = v
+		}
+		c.pos, c.key = i, k
+		return true
+	}
+	if i < 0 {
+		c.pos = -1
+	} else {
+		c.pos = c.keys.Len()
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package tiny_bitcask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCursorTestDB(t *testing.T) *DB {
+	t.Helper()
+	db := newTestDB(t, nil)
+	for _, k := range []string{"a1", "a2", "a3", "b1", "b2", "c1"} {
+		require.NoError(t, db.Set([]byte(k), []byte("v"+k)))
+	}
+	return db
+}
+
+func collect(c *Cursor, start func() bool, move func() bool) []string {
+	var out []string
+	for ok := start(); ok; ok = move() {
+		out = append(out, string(c.Key()))
+	}
+	return out
+}
+
+func TestCursor_Walk(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     *CursorOptions
+		forward  []string
+		backward []string
+	}{
+		{
+			name:     "default",
+			opts:     nil,
+			forward:  []string{"a1", "a2", "a3", "b1", "b2", "c1"},
+			backward: []string{"c1", "b2", "b1", "a3", "a2", "a1"},
+		},
+		{
+			name:     "prefix",
+			opts:     &CursorOptions{Prefix: []byte("b")},
+			forward:  []string{"b1", "b2"},
+			backward: []string{"b2", "b1"},
+		},
+		{
+			name:     "reverse_prefix",
+			opts:     &CursorOptions{Prefix: []byte("a"), Reverse: true},
+			forward:  []string{"a3", "a2", "a1"},
+			backward: []string{"a1", "a2", "a3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newCursorTestDB(t)
+			defer db.Close()
+			c := db.NewCursor(tt.opts)
+			defer c.Close()
+			assert.Equal(t, tt.forward, collect(c, c.First, c.Next))
+			assert.Equal(t, tt.backward, collect(c, c.Last, c.Prev))
+		})
+	}
+}
+
+func TestCursor_Seek(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *CursorOptions
+		seek    string
+		wantKey string
+		wantOK  bool
+	}{
+		{name: "exact", seek: "b1", wantKey: "b1", wantOK: true},
+		{name: "between_keys", seek: "a9", wantKey: "b1", wantOK: true},
+		{name: "past_end", seek: "z", wantOK: false},
+		{name: "reverse_between_keys", opts: &CursorOptions{Reverse: true}, seek: "a9", wantKey: "a3", wantOK: true},
+		{name: "reverse_exact", opts: &CursorOptions{Reverse: true}, seek: "b2", wantKey: "b2", wantOK: true},
+		{name: "reverse_before_start", opts: &CursorOptions{Reverse: true}, seek: "0", wantOK: false},
+		{name: "prefix_bounded", opts: &CursorOptions{Prefix: []byte("a")}, seek: "a4", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newCursorTestDB(t)
+			defer db.Close()
+			c := db.NewCursor(tt.opts)
+			assert.Equal(t, tt.wantOK, c.Seek([]byte(tt.seek)))
+			if tt.wantOK {
+				assert.Equal(t, tt.wantKey, string(c.Key()))
+				assert.Equal(t, "v"+tt.wantKey, string(c.Value()))
+			}
+		})
+	}
+}
+
+func TestCursor_ConcurrentWrites(t *testing.T) {
+	db := newCursorTestDB(t)
+	defer db.Close()
+	c := db.NewCursor(&CursorOptions{KeysOnly: true})
+	require.True(t, c.First())
+	assert.Nil(t, c.Value())
+
+	require.NoError(t, db.Delete([]byte("a2")))
+	require.NoError(t, db.Set([]byte("a25"), []byte("new")))
+	assert.True(t, c.Next())
+	assert.Equal(t, "a3", string(c.Key()), "deleted keys are skipped, new keys are not in the snapshot")
+
+	c.Refresh()
+	assert.True(t, c.Seek([]byte("a2")))
+	assert.Equal(t, "a25", string(c.Key()))
+}
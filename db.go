@@ -1,12 +1,13 @@
 package tiny_bitcask
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"sort"
 	"sync"
+	"time"
 	"tiny-bitcask/entity"
 	"tiny-bitcask/index"
 	"tiny-bitcask/storage"
@@ -16,14 +17,24 @@ var (
 	KeyNotFoundErr   = errors.New("key not found")
 	NoNeedToMergeErr = errors.New("no need to merge")
 	ReadOnlyDBErr    = errors.New("read-only database")
+	KeydirFullErr    = errors.New("keydir memory limit reached")
 )
 
 type DB struct {
 	rw       sync.RWMutex
-	kd       *index.KeyDir
+	kd       index.Index
 	storage  *storage.DataFiles
 	opt      *Options
 	lockFile *os.File
+	indexes  map[string]*secondaryIndex
+	counters dbCounters
+	watchers watchers
+	hist     *history   // superseded versions; nil unless Options.RetainVersions is set
+	ckptMu   sync.Mutex // serialises checkpoint writes; taken before rw
+
+	stopc    chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
 }
 
 // NewDB create a new DB instance with Options
@@ -31,6 +42,8 @@ func NewDB(opt *Options) (db *DB, err error) {
 	db = &DB{}
 	db.kd = index.NewKD()
 	db.opt = opt
+	db.stopc = make(chan struct{})
+	db.indexes = newSecondaryIndexes(opt.Indexes)
 
 	exists, err := isDirExist(opt.Dir)
 	if err != nil {
@@ -39,6 +52,15 @@ func NewDB(opt *Options) (db *DB, err error) {
 	if opt.ReadOnly && !exists {
 		return nil, fmt.Errorf("tiny-bitcask: read-only open: %w", os.ErrNotExist)
 	}
+	if opt.ReadOnly && opt.KeydirOnDisk {
+		return nil, errors.New("tiny-bitcask: KeydirOnDisk is not supported with ReadOnly")
+	}
+	if opt.RetainVersions.enabled() {
+		if opt.KeydirOnDisk {
+			return nil, errors.New("tiny-bitcask: RetainVersions is not supported with KeydirOnDisk")
+		}
+		db.hist = newHistory(opt.RetainVersions)
+	}
 
 	if exists {
 		lf, err := acquireDBLock(opt.Dir, opt.ReadOnly, opt.ExclusiveLock)
@@ -46,10 +68,17 @@ func NewDB(opt *Options) (db *DB, err error) {
 			return nil, err
 		}
 		db.lockFile = lf
+		if err := db.openKeydir(); err != nil {
+			_ = db.closeStorageAndLock()
+			return nil, err
+		}
+		start := time.Now()
 		if err := db.recovery(opt); err != nil {
 			_ = db.closeStorageAndLock()
 			return nil, err
 		}
+		db.counters.lastRecovery.Store(int64(time.Since(start)))
+		db.startBackground()
 		return db, nil
 	}
 
@@ -64,11 +93,64 @@ func NewDB(opt *Options) (db *DB, err error) {
 		return nil, err
 	}
 	db.lockFile = lf
+	if err := db.openKeydir(); err != nil {
+		_ = db.closeStorageAndLock()
+		return nil, err
+	}
+	db.startBackground()
 	return db, nil
 }
 
+// startBackground launches the periodic jobs enabled in Options; Close stops them.
+func (db *DB) startBackground() {
+	if db.opt.ReadOnly {
+		return
+	}
+	if db.opt.CheckpointInterval > 0 {
+		db.wg.Add(1)
+		go db.checkpointLoop(db.opt.CheckpointInterval)
+	}
+	if db.opt.ExpirySweepInterval > 0 {
+		db.wg.Add(1)
+		go db.sweepLoop(db.opt.ExpirySweepInterval)
+	}
+}
+
+func (db *DB) stopBackground() {
+	db.stopOnce.Do(func() {
+		close(db.stopc)
+	})
+	db.wg.Wait()
+}
+
+// openKeydir swaps in the on-disk keydir when Options.KeydirOnDisk is set.
+func (db *DB) openKeydir() error {
+	if !db.opt.KeydirOnDisk {
+		return nil
+	}
+	dk, err := index.OpenDiskKeyDir(db.opt.Dir, db.opt.KeydirCacheBytes)
+	if err != nil {
+		return err
+	}
+	db.kd = dk
+	return nil
+}
+
+// keydirErr surfaces an I/O failure of an on-disk keydir after a mutation.
+func (db *DB) keydirErr() error {
+	if p, ok := db.kd.(index.Persistent); ok {
+		return p.Err()
+	}
+	return nil
+}
+
 func (db *DB) closeStorageAndLock() error {
 	var first error
+	if db.kd != nil {
+		if err := db.kd.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
 	if db.storage != nil {
 		if err := db.storage.Close(); err != nil && first == nil {
 			first = err
@@ -84,18 +166,38 @@ func (db *DB) closeStorageAndLock() error {
 
 // Sync flushes the active data file (fsync). Thread-safe.
 func (db *DB) Sync() error {
-	db.rw.Lock()
-	defer db.rw.Unlock()
-	if db.storage == nil {
-		return nil
-	}
-	return db.storage.Sync()
+	return db.SyncContext(context.Background())
 }
 
-// Close syncs and releases file descriptors and the advisory lock.
+// Close stops background jobs, writes a final checkpoint when enabled, syncs and
+// releases file descriptors and the advisory lock.
 func (db *DB) Close() error {
+	db.stopBackground()
+	db.watchers.closeAll()
+	db.ckptMu.Lock()
+	defer db.ckptMu.Unlock()
 	db.rw.Lock()
 	defer db.rw.Unlock()
+	if db.storage != nil && !db.opt.ReadOnly && db.opt.CheckpointInterval > 0 {
+		cp, err := db.checkpointImage()
+		if err == nil {
+			err = storage.WriteCheckpoint(db.opt.Dir, cp)
+		}
+		if err != nil {
+			_ = db.closeStorageAndLock()
+			return err
+		}
+	}
+	if p, ok := db.kd.(index.Persistent); ok && db.storage != nil && p.Err() == nil {
+		if err := db.storage.Sync(); err != nil {
+			_ = db.closeStorageAndLock()
+			return err
+		}
+		if err := p.Flush(db.storage.ActiveFid(), db.storage.ActiveOffset(), db.storage.Seq()); err != nil {
+			_ = db.closeStorageAndLock()
+			return err
+		}
+	}
 	if err := db.storageSyncBestEffort(); err != nil {
 		_ = db.closeStorageAndLock()
 		return err
@@ -110,95 +212,210 @@ func (db *DB) storageSyncBestEffort() error {
 	return db.storage.Sync()
 }
 
-// ListKeys returns all keys in lexicographic order (snapshot under read lock).
+// ListKeys returns all keys in lexicographic order (snapshot under read lock),
+// or nil if an on-disk keydir cannot be read; Keys streams them instead.
 func (db *DB) ListKeys() [][]byte {
 	db.rw.RLock()
 	defer db.rw.RUnlock()
-	keys := db.kd.SortedKeys()
-	out := make([][]byte, len(keys))
-	for i, k := range keys {
-		out[i] = []byte(k)
+	var out [][]byte
+	now := time.Now().UnixNano()
+	err := db.kd.Ascend("", "", func(key string, dp *index.DataPosition) bool {
+		if !dp.Expired(now) {
+			out = append(out, []byte(key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil
 	}
 	return out
 }
 
 // Fold visits every key in sorted order and calls fn with the current value. Holds one read lock for the scan.
+// With Options.KeydirOnDisk keys come in table order instead, a chunk at a time.
 func (db *DB) Fold(fn func(key, value []byte) error) error {
-	db.rw.RLock()
-	defer db.rw.RUnlock()
-	for _, k := range db.kd.SortedKeys() {
-		dp := db.kd.Find(k)
-		if dp == nil {
-			continue
-		}
-		entry, err := db.storage.ReadEntry(dp)
-		if err != nil {
-			return err
-		}
-		if err := fn([]byte(k), entry.Value); err != nil {
-			return err
-		}
-	}
-	return nil
+	return db.FoldContext(context.Background(), fn)
 }
 
 // Set sets a key-value pairs into DB
 func (db *DB) Set(key []byte, value []byte) error {
-	db.rw.Lock()
-	defer db.rw.Unlock()
-	if db.opt.ReadOnly {
-		return ReadOnlyDBErr
+	return db.SetContext(context.Background(), key, value)
+}
+
+// put appends key = value and applies it. Caller holds db.rw for writing.
+func (db *DB) put(key, value []byte) (*entity.Hint, error) {
+	return db.putEntry(entity.NewEntryWithData(key, value))
+}
+
+// putEntry appends a prepared record and applies it. Caller holds db.rw for writing.
+func (db *DB) putEntry(entry *entity.Entry) (*entity.Hint, error) {
+	if err := db.checkKeydirLimit(entry.Key); err != nil {
+		return nil, err
 	}
-	entry := entity.NewEntryWithData(key, value)
 	h, err := db.storage.WriterEntity(entry)
 	if err != nil {
+		return nil, err
+	}
+	return h, db.applyPut(h, entry)
+}
+
+// applyPut points the keydir at a freshly written record and updates secondary
+// indexes. Caller holds db.rw for writing.
+func (db *DB) applyPut(h *entity.Hint, entry *entity.Entry) error {
+	db.retire(string(entry.Key), nil)
+	index.AddIndexByData(db.kd, h, entry)
+	if err := db.keydirErr(); err != nil {
+		return err
+	}
+	db.counters.sets.Add(1)
+	db.indexPut(entry.Key, entry.Value)
+	db.watchers.emit(entry.Key, OpSet, entry.Meta.Seq)
+	return nil
+}
+
+// applyDelete drops key from the keydir and secondary indexes after its
+// tombstone has been written, or after it expired (op OpExpire). tomb is the
+// tombstone (see tombstoneAt), nil on expiry, when no record is written and the
+// event carries the expired record's sequence number. Caller holds db.rw for
+// writing.
+func (db *DB) applyDelete(key []byte, op Op, tomb *index.DataPosition) error {
+	var seq uint64
+	if tomb != nil {
+		seq = tomb.Seq
+	} else if dp := db.kd.Find(string(key)); dp != nil {
+		seq = dp.Seq
+	}
+	if err := db.dropKey(key, tomb); err != nil {
 		return err
 	}
-	db.kd.AddIndexByData(h, entry)
+	db.watchers.emit(key, op, seq)
 	return nil
 }
 
+// dropKey is applyDelete without the change event. Caller holds db.rw for
+// writing.
+func (db *DB) dropKey(key []byte, tomb *index.DataPosition) error {
+	db.retire(string(key), tomb)
+	db.kd.Delete(string(key))
+	if err := db.keydirErr(); err != nil {
+		return err
+	}
+	db.indexDelete(key)
+	return nil
+}
+
+// checkKeydirLimit rejects a write to key once Options.MaxKeydirBytes would be
+// exceeded. Adding a new key grows the keydir; overwriting an existing one only
+// grows the retained history, and only while Options.RetainVersions is set.
+// Deletes are never refused. The on-disk keydir bounds its own memory, so the
+// limit does not apply to it.
+func (db *DB) checkKeydirLimit(key []byte) error {
+	if db.opt.MaxKeydirBytes <= 0 || db.opt.KeydirOnDisk {
+		return nil
+	}
+	if grow := db.keydirGrowth(string(key)); grow > 0 && db.keydirBytes()+grow > db.opt.MaxKeydirBytes {
+		return KeydirFullErr
+	}
+	return nil
+}
+
+// keydirGrowth estimates how many bytes a put of key adds to keydirBytes.
+// Caller holds db.rw.
+func (db *DB) keydirGrowth(key string) int64 {
+	if db.kd.Find(key) == nil {
+		return index.EntryBytes(key)
+	}
+	if db.hist != nil {
+		return db.hist.growth(key)
+	}
+	return 0
+}
+
+// keydirBytes estimates the heap held by the keydir and the retained history.
+// Caller holds db.rw.
+func (db *DB) keydirBytes() int64 {
+	n := db.kd.Bytes()
+	if db.hist != nil {
+		n += db.hist.bytes
+	}
+	return n
+}
+
 // Get gets value by using key
 func (db *DB) Get(key []byte) (value []byte, err error) {
-	db.rw.RLock()
-	defer db.rw.RUnlock()
-	i := db.kd.Find(string(key))
-	if i == nil {
-		return nil, KeyNotFoundErr
+	return db.GetContext(context.Background(), key)
+}
+
+// find looks key up in the keydir, treating a record whose TTL has passed as
+// missing until the sweeper drops it. Caller holds db.rw.
+func (db *DB) find(key string) *index.DataPosition {
+	dp := db.kd.Find(key)
+	if dp == nil || dp.Expired(time.Now().UnixNano()) {
+		return nil
 	}
-	entry, err := db.storage.ReadEntry(i)
-	if err != nil {
-		return nil, err
+	return dp
+}
+
+// walkChunkKeys bounds the keys eachKey holds at once over a Walker keydir.
+const walkChunkKeys = 1024
+
+// eachKey calls fn for every key in the keydir, expired or not: in sorted
+// order for the in-memory keydir, and in table order a chunk at a time for
+// one that implements index.Walker, so the pass never holds every key. Caller
+// holds db.rw.
+func (db *DB) eachKey(fn func(key string) error) error {
+	var ferr error
+	w, ok := db.kd.(index.Walker)
+	if !ok {
+		err := db.kd.Ascend("", "", func(key string, _ *index.DataPosition) bool {
+			ferr = fn(key)
+			return ferr == nil
+		})
+		if ferr != nil {
+			return ferr
+		}
+		return err
 	}
-	return entry.Value, nil
+	err := w.Walk(walkChunkKeys, func(keys []string) bool {
+		for _, k := range keys {
+			if ferr = fn(k); ferr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if ferr != nil {
+		return ferr
+	}
+	return err
 }
 
 // Delete delete a key
 func (db *DB) Delete(key []byte) error {
-	db.rw.Lock()
-	defer db.rw.Unlock()
-	if db.opt.ReadOnly {
-		return ReadOnlyDBErr
-	}
-	keyStr := string(key)
-	index := db.kd.Find(keyStr)
-	if index == nil {
-		return KeyNotFoundErr
-	}
+	return db.DeleteContext(context.Background(), key)
+}
+
+// remove appends a tombstone for key and applies it. Caller holds db.rw for
+// writing.
+func (db *DB) remove(key []byte) error {
 	e := entity.NewTombstoneEntry(key)
-	_, err := db.storage.WriterEntity(e)
+	h, err := db.storage.WriterEntity(e)
 	if err != nil {
 		return err
 	}
-	db.kd.Delete(keyStr)
-	return nil
+	db.counters.deletes.Add(1)
+	return db.applyDelete(key, OpDelete, db.tombstoneAt(h, e))
 }
 
 // Merge compacts old segments: copies live records still stored only in mergeable
 // files into the active file, then removes those segment files.
 func (db *DB) Merge() error {
-	db.rw.Lock()
-	defer db.rw.Unlock()
+	return db.MergeContext(context.Background())
+}
+
+// merge is Merge's body, checking ctx between segments. Caller holds db.rw for
+// writing.
+func (db *DB) merge(ctx context.Context) error {
 	if db.opt.ReadOnly {
 		return ReadOnlyDBErr
 	}
@@ -206,123 +423,64 @@ func (db *DB) Merge() error {
 	if len(fids) < 2 {
 		return NoNeedToMergeErr
 	}
+	// Merge moves records and deletes segments, which a checkpoint may reference.
+	if err := storage.RemoveCheckpoint(db.opt.Dir); err != nil {
+		return err
+	}
+	// Likewise an on-disk keydir left behind by an earlier KeydirOnDisk session.
+	if !db.opt.KeydirOnDisk {
+		if err := index.RemoveDiskKeyDir(db.opt.Dir); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
 	toMerge := append([]int(nil), fids...)
 	sort.Ints(toMerge)
 	for _, fid := range toMerge[:len(toMerge)-1] {
+		if err := ctx.Err(); err != nil {
+			return ctxErr("merge", err)
+		}
 		if err := db.mergeOldFile(fid); err != nil {
 			return err
 		}
 	}
+	db.counters.merges.Add(1)
+	db.counters.lastMerge.Store(int64(time.Since(start)))
 	return nil
 }
 
 func (db *DB) mergeOldFile(fid int) error {
 	reader := db.storage.GetOldFile(fid)
-	var off int64
-	for {
-		entry, err := reader.ReadEntityWithOutLength(off)
-		if err != nil {
-			if err == io.EOF {
-				return db.storage.RemoveFile(fid)
-			}
-			return err
-		}
-		if entry.Meta.Flag == entity.DeleteFlag {
-			off += entry.Size()
-			continue
+	now := time.Now().UnixNano()
+	_, err := reader.Scan(0, func(entryOff int64, entry *entity.Entry) error {
+		// Tombstones are dropped: merge always takes the oldest segments, so every
+		// record they could shadow is removed along with them.
+		if entry.Meta.Flag == entity.DeleteFlag || entry.Meta.Flag == entity.RangeDeleteFlag {
+			return nil
 		}
 		// entryOff is the record start offset; keydir stores the same (see IsEqualPos).
-		entryOff := off
-		off += entry.Size()
-
 		idx := db.kd.Find(string(entry.Key))
 		if idx == nil || !idx.IsEqualPos(fid, entryOff) {
-			continue
+			return nil
 		}
-		h, err := db.storage.WriterEntity(entry)
+		// An expired record is not copied; every older record of the key lives in
+		// this or an earlier segment, which merge removes too, so no tombstone is needed.
+		if idx.Expired(now) {
+			return db.applyDelete(entry.Key, OpExpire, nil)
+		}
+		h, err := db.storage.CopyEntity(entry)
 		if err != nil {
 			return err
 		}
-		db.kd.AddIndexByData(h, entry)
-	}
-}
-
-// recovery  will rebuild a db from existing dir
-func (db *DB) recovery(opt *Options) (err error) {
-	var fileSize = getSegmentSize(opt.SegmentSize)
-	db.storage, err = storage.NewDataFileWithFiles(opt.Dir, fileSize, opt.VerifyCRC, opt.ReadOnly)
+		// Only the position moves; the value and so its secondary index terms are unchanged.
+		index.AddIndexByData(db.kd, h, entry)
+		return db.keydirErr()
+	})
 	if err != nil {
 		return err
 	}
-	fids, err := storage.ListDataFileIDs(opt.Dir)
-	if err != nil {
+	if err := db.relocateHistory(fid); err != nil {
 		return err
 	}
-	for i, fid := range fids {
-		isActive := i == len(fids)-1
-		if err := db.recoverSegment(fid, opt.Dir, isActive, opt.VerifyCRC); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (db *DB) recoverFromHint(fid int, dir string) error {
-	recs, err := storage.ReadHintFile(dir, fid)
-	if err != nil {
-		return err
-	}
-	datPath := storage.DataFilePath(dir, fid)
-	st, err := os.Stat(datPath)
-	if err != nil {
-		return err
-	}
-	datSize := st.Size()
-	for _, r := range recs {
-		if r.Flag == entity.DeleteFlag {
-			continue
-		}
-		if int(r.KeySize) != len(r.Key) {
-			return errors.New("hint key length mismatch")
-		}
-		recLen := int64(entity.MetaSize + r.KeySize + r.ValueSize)
-		if r.RecordOffset < 0 || r.RecordOffset+recLen > datSize {
-			return errors.New("hint record out of range for data file")
-		}
-		db.kd.AddIndexBySizes(fid, r.RecordOffset, r.Key, int(r.KeySize), int(r.ValueSize), r.Timestamp)
-	}
-	return nil
-}
-
-func (db *DB) recoverSegment(fid int, dir string, isActive bool, verifyCRC bool) error {
-	if !isActive && storage.HintFileExists(dir, fid) {
-		if err := db.recoverFromHint(fid, dir); err == nil {
-			return nil
-		}
-	}
-
-	path := storage.DataFilePath(dir, fid)
-	of, err := storage.NewOldFile(path, verifyCRC)
-	if err != nil {
-		return err
-	}
-	defer of.Close()
-	var off int64
-	for {
-		entry, err := of.ReadEntityWithOutLength(off)
-		if err == nil {
-			if entry.Meta.Flag == entity.DeleteFlag {
-				db.kd.Delete(string(entry.Key))
-			} else {
-				db.kd.AddIndexByRawInfo(fid, off, entry.Key, entry.Value, entry.Meta.TimeStamp)
-			}
-			off += entry.Size()
-		} else {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-	}
-	return nil
+	return db.storage.RemoveFile(fid)
 }
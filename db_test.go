@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -328,19 +330,31 @@ func TestDB_Delete_Merge(t *testing.T) {
 
 func TestDB_Recovery_TombstoneRemovesKey(t *testing.T) {
 	tests := []struct {
-		name string
+		name   string
+		sealed bool // value and tombstone in sealed segments, replayed from hints
 	}{
 		{name: "delete_then_reopen"},
+		{name: "delete_in_hinted_segment", sealed: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			dataDir := filepath.Join(t.TempDir(), "tombdb")
 			opt := *DefaultOptions
 			opt.Dir = dataDir
+			if tt.sealed {
+				opt.SegmentSize = storage.KB
+			}
 			db1, err := NewDB(&opt)
 			require.NoError(t, err)
 			require.NoError(t, db1.Set([]byte("k"), []byte("v")))
+			fill := func() {
+				for fid := db1.storage.ActiveFid(); tt.sealed && db1.storage.ActiveFid() == fid; {
+					require.NoError(t, db1.Set([]byte("filler"), make([]byte, 100)))
+				}
+			}
+			fill()
 			require.NoError(t, db1.Delete([]byte("k")))
+			fill()
 			require.NoError(t, db1.Close())
 
 			db2, err := NewDB(&opt)
@@ -445,3 +459,112 @@ func TestDB_ReadOnly(t *testing.T) {
 		})
 	}
 }
+
+func TestDB_KeydirOnDisk(t *testing.T) {
+	tests := []struct {
+		name  string
+		flush bool
+	}{
+		{name: "clean_close_resumes_from_covered_position", flush: true},
+		{name: "unclean_shutdown_rebuilds_from_segments", flush: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir := filepath.Join(t.TempDir(), "diskkd")
+			opt := *DefaultOptions
+			opt.Dir = dataDir
+			opt.SegmentSize = 4 * storage.KB
+			opt.KeydirOnDisk = true
+			opt.KeydirCacheBytes = 16 * storage.KB
+
+			db1, err := NewDB(&opt)
+			require.NoError(t, err)
+			for i := 0; i < 3000; i++ {
+				require.NoError(t, db1.Set([]byte(fmt.Sprintf("k_%d", i)), []byte(fmt.Sprintf("v_%d", i))))
+			}
+			require.NoError(t, db1.Delete([]byte("k_7")))
+			require.NoError(t, db1.Merge())
+			if tt.flush {
+				require.NoError(t, db1.Close())
+			} else {
+				// Simulate a crash: release files without the final keydir flush.
+				db1.stopBackground()
+				require.NoError(t, db1.storage.Sync())
+				require.NoError(t, db1.closeStorageAndLock())
+			}
+
+			db2, err := NewDB(&opt)
+			require.NoError(t, err)
+			defer db2.Close()
+			assert.Equal(t, 2999, db2.Stats().KeyCount)
+			got, err := db2.Get([]byte("k_2999"))
+			require.NoError(t, err)
+			assert.Equal(t, "v_2999", string(got))
+			got, err = db2.Get([]byte("k_0"))
+			require.NoError(t, err)
+			assert.Equal(t, "v_0", string(got))
+			_, err = db2.Get([]byte("k_7"))
+			assert.ErrorIs(t, err, KeyNotFoundErr)
+		})
+	}
+}
+
+func TestDB_KeydirOnDisk_Iteration(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.KeydirOnDisk = true
+		o.KeydirCacheBytes = 16 * storage.KB
+	})
+	defer db.Close()
+	for i := 2999; i >= 0; i-- {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k_%04d", i)), []byte(fmt.Sprintf("v_%d", i))))
+	}
+	assert.LessOrEqual(t, db.Stats().KeydirBytes, int64(16*storage.KB), "the slot table must stay within KeydirCacheBytes")
+
+	var keys []string
+	for k := range db.Keys() {
+		keys = append(keys, string(k))
+	}
+	require.Len(t, keys, 3000)
+	assert.True(t, sort.StringsAreSorted(keys))
+
+	n := 0
+	for k, v := range db.Scan([]byte("k_0100"), []byte("k_0200")) {
+		assert.Equal(t, "v_"+strings.TrimLeft(string(k)[2:], "0"), string(v))
+		n++
+	}
+	assert.Equal(t, 100, n)
+
+	c := db.NewCursor(&CursorOptions{Reverse: true})
+	require.True(t, c.Seek([]byte("k_1500x")))
+	assert.Equal(t, "k_1500", string(c.Key()))
+	require.True(t, c.Next())
+	assert.Equal(t, "k_1499", string(c.Key()))
+	c.Close()
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+	require.NoError(t, db.Delete([]byte("k_0042")))
+	got, err := snap.Get([]byte("k_0042"))
+	require.NoError(t, err)
+	assert.Equal(t, "v_42", string(got))
+	assert.Equal(t, 3000, snap.Len())
+	require.NoError(t, snap.Release())
+
+	left, err := filepath.Glob(filepath.Join(db.opt.Dir, "keydir-tmp-*"))
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+func TestDB_KeydirOnDisk_ReadOnlyRejected(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "diskro")
+	opt := *DefaultOptions
+	opt.Dir = dataDir
+	db1, err := NewDB(&opt)
+	require.NoError(t, err)
+	require.NoError(t, db1.Close())
+
+	opt.ReadOnly = true
+	opt.KeydirOnDisk = true
+	_, err = NewDB(&opt)
+	assert.Error(t, err)
+}
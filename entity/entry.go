@@ -8,7 +8,7 @@ import (
 
 // VerifyRecordCRC checks the stored CRC32 against meta+payload bytes (matches Encode).
 func VerifyRecordCRC(buf []byte) bool {
-	if len(buf) < MetaSize {
+	if len(buf) < LegacyMetaSize {
 		return false
 	}
 	got := binary.LittleEndian.Uint32(buf[0:4])
@@ -17,8 +17,26 @@ func VerifyRecordCRC(buf []byte) bool {
 }
 
 const (
-	MetaSize   = 29
-	DeleteFlag = 1
+	MetaSize = 37
+	// LegacyMetaSize is the meta length of records in segments written before
+	// the expiry field was added (bytes 0:29 of today's layout); they never expire.
+	LegacyMetaSize = 29
+	DeleteFlag     = 1
+	// BatchFlag marks the header record of an atomic batch; BatchCommitFlag marks
+	// its commit record. Both carry the batch's record count as their value.
+	BatchFlag       = 2
+	BatchCommitFlag = 3
+	// RangeDeleteFlag marks a range tombstone: every key in [Key, Value) written
+	// before it is deleted. An empty Value leaves the range unbounded above.
+	RangeDeleteFlag = 4
+	// VersionFlag and VersionDeleteFlag mark copies merge makes of a superseded
+	// value or of a delete, to keep retained history readable. They never
+	// become a key's current value.
+	VersionFlag       = 5
+	VersionDeleteFlag = 6
+	// CounterFlag marks a counter written by IncrBy/DecrBy: an ordinary value
+	// holding an 8-byte little-endian int64.
+	CounterFlag = 7
 )
 
 type Hint struct {
@@ -34,11 +52,12 @@ type Entry struct {
 
 type Meta struct {
 	Crc       uint32
-	position  uint64
+	Seq       uint64 // global write order, assigned by storage; 0 for batch markers
 	TimeStamp uint64
 	KeySize   uint32
 	ValueSize uint32
 	Flag      uint8
+	Expiry    uint64 // unix nanoseconds after which the record is dead; 0 = never
 }
 
 func NewEntryWithData(key []byte, value []byte) *Entry {
@@ -59,14 +78,49 @@ func NewTombstoneEntry(key []byte) *Entry {
 	return NewEntry().WithKey(key).WithValue(nil).WithMeta(meta)
 }
 
+// NewRangeTombstone builds a record deleting keys in [start, end); a nil or
+// empty end means every key from start on.
+func NewRangeTombstone(start, end []byte) *Entry {
+	now := uint64(time.Now().Unix())
+	meta := NewMeta().
+		WithTimeStamp(now).
+		WithKeySize(uint32(len(start))).
+		WithValueSize(uint32(len(end))).
+		WithFlag(RangeDeleteFlag)
+	return NewEntry().WithKey(start).WithValue(end).WithMeta(meta)
+}
+
+// NewBatchMarker builds a batch header (BatchFlag) or commit (BatchCommitFlag)
+// record for a batch of count records.
+func NewBatchMarker(flag uint8, count uint32) *Entry {
+	now := uint64(time.Now().Unix())
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, count)
+	meta := NewMeta().
+		WithTimeStamp(now).
+		WithKeySize(0).
+		WithValueSize(uint32(len(value))).
+		WithFlag(flag)
+	return NewEntry().WithValue(value).WithMeta(meta)
+}
+
+// IsBatchMarker reports whether e is a batch header or commit record.
+func (e *Entry) IsBatchMarker() bool {
+	return e.Meta.Flag == BatchFlag || e.Meta.Flag == BatchCommitFlag
+}
+
+// BatchCount returns the record count carried by a batch marker.
+func (e *Entry) BatchCount() uint32 {
+	if len(e.Value) < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(e.Value)
+}
+
 func (e *Entry) Encode() []byte {
 	size := e.Size()
 	buf := make([]byte, size)
-	binary.LittleEndian.PutUint64(buf[4:12], e.Meta.position)
-	binary.LittleEndian.PutUint64(buf[12:20], e.Meta.TimeStamp)
-	binary.LittleEndian.PutUint32(buf[20:24], e.Meta.KeySize)
-	binary.LittleEndian.PutUint32(buf[24:28], e.Meta.ValueSize)
-	buf[28] = e.Meta.Flag
+	e.Meta.Encode(buf)
 	if e.Meta.Flag == DeleteFlag {
 		copy(buf[MetaSize:MetaSize+len(e.Key)], e.Key)
 	} else {
@@ -78,6 +132,17 @@ func (e *Entry) Encode() []byte {
 	return buf
 }
 
+// Encode writes every meta field except the CRC into buf[4:MetaSize]; the CRC
+// covers these bytes followed by key and value.
+func (m *Meta) Encode(buf []byte) {
+	binary.LittleEndian.PutUint64(buf[4:12], m.Seq)
+	binary.LittleEndian.PutUint64(buf[12:20], m.TimeStamp)
+	binary.LittleEndian.PutUint32(buf[20:24], m.KeySize)
+	binary.LittleEndian.PutUint32(buf[24:28], m.ValueSize)
+	buf[28] = m.Flag
+	binary.LittleEndian.PutUint64(buf[29:37], m.Expiry)
+}
+
 func (e *Entry) DecodePayload(payload []byte) {
 	keyHighBound := int(e.Meta.KeySize)
 	valueHighBound := keyHighBound + int(e.Meta.ValueSize)
@@ -85,13 +150,19 @@ func (e *Entry) DecodePayload(payload []byte) {
 	e.Value = payload[keyHighBound:valueHighBound]
 }
 
+// DecodeMeta decodes a MetaSize meta, or a LegacyMetaSize one, which leaves
+// Expiry 0.
 func (e *Entry) DecodeMeta(bytes []byte) {
 	e.Meta.Crc = binary.LittleEndian.Uint32(bytes[0:4])
-	e.Meta.position = binary.LittleEndian.Uint64(bytes[4:12])
+	e.Meta.Seq = binary.LittleEndian.Uint64(bytes[4:12])
 	e.Meta.TimeStamp = binary.LittleEndian.Uint64(bytes[12:20])
 	e.Meta.KeySize = binary.LittleEndian.Uint32(bytes[20:24])
 	e.Meta.ValueSize = binary.LittleEndian.Uint32(bytes[24:28])
 	e.Meta.Flag = bytes[28]
+	e.Meta.Expiry = 0
+	if len(bytes) >= MetaSize {
+		e.Meta.Expiry = binary.LittleEndian.Uint64(bytes[29:37])
+	}
 }
 
 func (e *Entry) Size() int64 {
@@ -128,8 +199,8 @@ func NewMeta() *Meta {
 	return new(Meta)
 }
 
-func (m *Meta) WithPosition(pos uint64) *Meta {
-	m.position = pos
+func (m *Meta) WithSeq(seq uint64) *Meta {
+	m.Seq = seq
 	return m
 }
 
@@ -153,6 +224,11 @@ func (m *Meta) WithFlag(flag uint8) *Meta {
 	return m
 }
 
+func (m *Meta) WithExpiry(expiry uint64) *Meta {
+	m.Expiry = expiry
+	return m
+}
+
 func NewHint() *Hint {
 	return new(Hint)
 }
@@ -64,3 +64,16 @@ func TestNewTombstoneEntry_RoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestEntry_ExpiryRoundTrip(t *testing.T) {
+	e := NewEntryWithData([]byte("k"), []byte("v"))
+	e.Meta.WithExpiry(1234567890)
+	buf := e.Encode()
+	require.True(t, VerifyRecordCRC(buf))
+
+	got := NewEntry().WithMeta(NewMeta())
+	got.DecodeMeta(buf[:MetaSize])
+	got.DecodePayload(buf[MetaSize:])
+	assert.Equal(t, uint64(1234567890), got.Meta.Expiry)
+	assert.Equal(t, "v", string(got.Value))
+}
@@ -0,0 +1,282 @@
+package tiny_bitcask
+
+import (
+	"sort"
+	"time"
+	"unsafe"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+)
+
+// VersionRetention selects which superseded versions of a key stay readable
+// through GetAt and History (Options.RetainVersions). The zero value keeps
+// none. A delete counts as a version. With both limits set a version must
+// satisfy both.
+type VersionRetention struct {
+	Count int           // keep at most this many older versions per key
+	Age   time.Duration // keep older versions superseded less than Age ago
+}
+
+func (r VersionRetention) enabled() bool {
+	return r.Count > 0 || r.Age > 0
+}
+
+// Revision is one version of a key as returned by History.
+type Revision struct {
+	Timestamp time.Time // write time, one-second resolution
+	Value     []byte
+	Deleted   bool // the key was deleted; Value is nil
+}
+
+// pastVersion is a superseded value of a key, or a delete of it.
+type pastVersion struct {
+	dp      *index.DataPosition // the value record, or the delete's tombstone
+	deleted bool
+}
+
+// history keeps the superseded versions of each key, oldest first, beside the
+// keydir, which still holds the current version. Retained records stay on
+// disk: merge copies the ones in segments it removes (VersionFlag and
+// VersionDeleteFlag) and recovery replays every segment to rebuild the
+// history, so hints and checkpoints are not used while retention is on.
+// bytes estimates the heap it holds and counts toward Options.MaxKeydirBytes.
+type history struct {
+	keep  VersionRetention
+	m     map[string][]pastVersion
+	bytes int64
+}
+
+// historyKeyBytes estimates the heap one key with retained versions costs
+// besides the versions themselves: the key, its map slot and slice header.
+func historyKeyBytes(key string) int64 {
+	return index.KeyBytes(key) + int64(unsafe.Sizeof([]pastVersion{}))
+}
+
+// versionBytes estimates the heap one retained version costs: its
+// DataPosition and its slot in the key's slice.
+var versionBytes = index.PositionBytes() + int64(unsafe.Sizeof(pastVersion{}))
+
+func newHistory(keep VersionRetention) *history {
+	return &history{keep: keep, m: map[string][]pastVersion{}}
+}
+
+// insert adds v to key's versions in sequence order. Timestamps have
+// one-second resolution, and recovery inserts a version merge relocated only
+// when it replays the segment holding the copy, so they cannot order versions.
+func (h *history) insert(key string, v pastVersion) {
+	vs := h.m[key]
+	if len(vs) == 0 {
+		h.bytes += historyKeyBytes(key)
+	}
+	h.bytes += versionBytes
+	i := sort.Search(len(vs), func(i int) bool { return vs[i].dp.Seq > v.dp.Seq })
+	vs = append(vs, pastVersion{})
+	copy(vs[i+1:], vs[i:])
+	vs[i] = v
+	h.m[key] = vs
+}
+
+// prune drops key's versions outside the retention limits. head is the write
+// time of the current version, or 0 when the key has none; a version's age
+// counts from the write that superseded it. Leading deletes hide nothing and
+// go too.
+func (h *history) prune(key string, head uint64) {
+	vs := h.m[key]
+	before := len(vs)
+	if n := h.keep.Count; n > 0 && len(vs) > n {
+		vs = vs[len(vs)-n:]
+	}
+	if h.keep.Age > 0 {
+		cutoff := uint64(time.Now().Add(-h.keep.Age).Unix())
+		drop := 0
+		for i := range vs {
+			next := head
+			if i+1 < len(vs) {
+				next = vs[i+1].dp.Timestamp
+			}
+			if next == 0 || next >= cutoff {
+				break
+			}
+			drop = i + 1
+		}
+		vs = vs[drop:]
+	}
+	for len(vs) > 0 && vs[0].deleted {
+		vs = vs[1:]
+	}
+	h.bytes -= int64(before-len(vs)) * versionBytes
+	if len(vs) == 0 {
+		if before > 0 {
+			h.bytes -= historyKeyBytes(key)
+		}
+		delete(h.m, key)
+		return
+	}
+	h.m[key] = vs
+}
+
+// growth estimates how many bytes retiring key's current version adds: one
+// more version, unless the count limit already holds key at its cap, plus the
+// key itself if it has no history yet.
+func (h *history) growth(key string) int64 {
+	vs := h.m[key]
+	switch {
+	case len(vs) == 0:
+		return historyKeyBytes(key) + versionBytes
+	case h.keep.Count > 0 && len(vs) >= h.keep.Count:
+		return 0
+	default:
+		return versionBytes
+	}
+}
+
+// retire moves key's current keydir entry, if any, into the history before it
+// is replaced or removed; tomb, when non-nil, records a delete after it.
+// Caller holds db.rw for writing and updates the keydir afterwards.
+func (db *DB) retire(key string, tomb *index.DataPosition) {
+	if db.hist == nil {
+		return
+	}
+	if old := db.kd.Find(key); old != nil {
+		db.hist.insert(key, pastVersion{dp: old})
+	}
+	if tomb != nil {
+		db.hist.insert(key, pastVersion{dp: tomb, deleted: true})
+	}
+	db.hist.prune(key, 0)
+}
+
+// tombstoneAt describes tombstone e, just written at h, for retire and the
+// change event.
+func (db *DB) tombstoneAt(h *entity.Hint, e *entity.Entry) *index.DataPosition {
+	return &index.DataPosition{Fid: h.Fid, Off: h.Off, Timestamp: e.Meta.TimeStamp, Seq: e.Meta.Seq}
+}
+
+// relocateHistory copies retained versions stored in segment fid to the
+// active segment so merge can remove fid. Caller holds db.rw for writing.
+func (db *DB) relocateHistory(fid int) error {
+	if db.hist == nil {
+		return nil
+	}
+	for key, vs := range db.hist.m {
+		var head uint64
+		if dp := db.kd.Find(key); dp != nil {
+			head = dp.Timestamp
+		}
+		db.hist.prune(key, head)
+		vs = db.hist.m[key]
+		for i, v := range vs {
+			if v.dp.Fid != fid {
+				continue
+			}
+			dp, err := db.copyVersion(key, v)
+			if err != nil {
+				return err
+			}
+			vs[i].dp = dp
+		}
+	}
+	return nil
+}
+
+// copyVersion appends a VersionFlag or VersionDeleteFlag copy of v, keeping
+// its timestamp, expiry and sequence number, and returns the copy's position.
+func (db *DB) copyVersion(key string, v pastVersion) (*index.DataPosition, error) {
+	var e *entity.Entry
+	if v.deleted {
+		e = entity.NewTombstoneEntry([]byte(key))
+		e.Meta.WithFlag(entity.VersionDeleteFlag)
+	} else {
+		old, err := db.storage.ReadEntry(v.dp)
+		if err != nil {
+			return nil, err
+		}
+		e = entity.NewEntryWithData([]byte(key), cloneBytes(old.Value))
+		e.Meta.WithFlag(entity.VersionFlag).WithExpiry(v.dp.Expiry)
+	}
+	e.Meta.WithTimeStamp(v.dp.Timestamp).WithSeq(v.dp.Seq)
+	h, err := db.storage.CopyEntity(e)
+	if err != nil {
+		return nil, err
+	}
+	return &index.DataPosition{
+		Fid:       h.Fid,
+		Off:       h.Off,
+		Timestamp: v.dp.Timestamp,
+		KeySize:   int(e.Meta.KeySize),
+		ValueSize: int(e.Meta.ValueSize),
+		Expiry:    v.dp.Expiry,
+		Seq:       v.dp.Seq,
+	}, nil
+}
+
+// GetAt returns the value key had at time t: the newest version written at or
+// before t, unless that version is a delete or had expired by t. Versions
+// older than the current one are only found while Options.RetainVersions
+// keeps them. Timestamps have one-second resolution.
+func (db *DB) GetAt(key []byte, t time.Time) ([]byte, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	k, ts := string(key), uint64(t.Unix())
+	v, ok := pastVersion{dp: db.kd.Find(k)}, false
+	if v.dp != nil && v.dp.Timestamp <= ts {
+		ok = true
+	} else if db.hist != nil {
+		vs := db.hist.m[k]
+		for i := len(vs) - 1; i >= 0; i-- {
+			if vs[i].dp.Timestamp <= ts {
+				v, ok = vs[i], true
+				break
+			}
+		}
+	}
+	if !ok || v.deleted || (v.dp.Expiry != 0 && v.dp.Expiry <= uint64(t.UnixNano())) {
+		return nil, KeyNotFoundErr
+	}
+	return db.readVersion(v.dp)
+}
+
+// History returns the retained versions of key, oldest first, ending with the
+// current one if the key exists. It fails with KeyNotFoundErr when there are
+// none.
+func (db *DB) History(key []byte) ([]Revision, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	k := string(key)
+	var vs []pastVersion
+	if db.hist != nil {
+		vs = append(vs, db.hist.m[k]...)
+	}
+	if dp := db.kd.Find(k); dp != nil {
+		vs = append(vs, pastVersion{dp: dp})
+	}
+	if len(vs) == 0 {
+		return nil, KeyNotFoundErr
+	}
+	out := make([]Revision, len(vs))
+	for i, v := range vs {
+		out[i] = Revision{Timestamp: time.Unix(int64(v.dp.Timestamp), 0), Deleted: v.deleted}
+		if v.deleted {
+			continue
+		}
+		value, err := db.readVersion(v.dp)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Value = value
+	}
+	return out, nil
+}
+
+// readVersion returns a copy of the value at dp. Caller holds db.rw.
+func (db *DB) readVersion(dp *index.DataPosition) ([]byte, error) {
+	value, release, err := db.storage.ReadValue(dp)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return cloneBytes(value), nil
+}
@@ -0,0 +1,185 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/storage"
+)
+
+// setAt writes key = value stamped ts (unix seconds), as Set would have then.
+func setAt(t *testing.T, db *DB, key, value string, ts int64) {
+	t.Helper()
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	e := entity.NewEntryWithData([]byte(key), []byte(value))
+	e.Meta.WithTimeStamp(uint64(ts))
+	_, err := db.putEntry(e)
+	require.NoError(t, err)
+}
+
+// deleteAt deletes key with a tombstone stamped ts, as Delete would have then.
+func deleteAt(t *testing.T, db *DB, key string, ts int64) {
+	t.Helper()
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	e := entity.NewTombstoneEntry([]byte(key))
+	e.Meta.WithTimeStamp(uint64(ts))
+	h, err := db.storage.WriterEntity(e)
+	require.NoError(t, err)
+	require.NoError(t, db.applyDelete([]byte(key), OpDelete, db.tombstoneAt(h, e)))
+}
+
+func TestHistory_GetAtAcrossReopenAndMerge(t *testing.T) {
+	opt := func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+		o.RetainVersions = VersionRetention{Count: 10}
+	}
+	db := newTestDB(t, opt)
+	t0 := time.Now().Add(-time.Hour).Unix()
+	setAt(t, db, "k", "v1", t0)
+	setAt(t, db, "k", "v2", t0+10)
+	deleteAt(t, db, "k", t0+20)
+	setAt(t, db, "k", "v3", t0+30)
+
+	check := func(db *DB) {
+		t.Helper()
+		for _, c := range []struct {
+			at   int64
+			want string
+		}{{-1, ""}, {5, "v1"}, {15, "v2"}, {25, ""}, {35, "v3"}} {
+			got, err := db.GetAt([]byte("k"), time.Unix(t0+c.at, 0))
+			if c.want == "" {
+				assert.ErrorIs(t, err, KeyNotFoundErr, "at %d", c.at)
+				continue
+			}
+			require.NoError(t, err, "at %d", c.at)
+			assert.Equal(t, c.want, string(got), "at %d", c.at)
+		}
+		revs, err := db.History([]byte("k"))
+		require.NoError(t, err)
+		require.Len(t, revs, 4)
+		assert.Equal(t, "v1", string(revs[0].Value))
+		assert.True(t, revs[2].Deleted)
+		assert.Equal(t, time.Unix(t0+30, 0), revs[3].Timestamp)
+	}
+	check(db)
+
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	o := Options{Dir: dir}
+	opt(&o)
+	db, err := NewDB(&o)
+	require.NoError(t, err)
+	check(db)
+
+	// Push the history into segments merge will remove.
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("fill-%03d", i)), []byte(fmt.Sprintf("%060d", i))))
+	}
+	require.Greater(t, len(db.storage.GetOldFiles()), 2)
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+	db, err = NewDB(&o)
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
+
+// TestHistory_SameSecondOrderAfterMerge relocates the older of two versions
+// written in the same second, so recovery meets the copy after the newer one.
+func TestHistory_SameSecondOrderAfterMerge(t *testing.T) {
+	opt := func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+		o.RetainVersions = VersionRetention{Count: 10}
+	}
+	db := newTestDB(t, opt)
+	ts := time.Now().Add(-time.Hour).Unix()
+	fill := 0
+	sealWith := func(segments int) {
+		for len(db.storage.GetOldFiles()) < segments {
+			require.NoError(t, db.Set([]byte(fmt.Sprintf("fill-%03d", fill)), []byte(fmt.Sprintf("%060d", fill))))
+			fill++
+		}
+	}
+	setAt(t, db, "k", "v1", ts)
+	sealWith(1)
+	setAt(t, db, "k", "v2", ts)
+	sealWith(2)
+	setAt(t, db, "k", "v3", ts+10)
+
+	check := func(db *DB) {
+		t.Helper()
+		revs, err := db.History([]byte("k"))
+		require.NoError(t, err)
+		var got []string
+		for _, r := range revs {
+			got = append(got, string(r.Value))
+		}
+		assert.Equal(t, []string{"v1", "v2", "v3"}, got)
+		v, err := db.GetAt([]byte("k"), time.Unix(ts, 0))
+		require.NoError(t, err)
+		assert.Equal(t, "v2", string(v))
+	}
+	require.NoError(t, db.Merge())
+	check(db)
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	o := Options{Dir: dir}
+	opt(&o)
+	db, err := NewDB(&o)
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
+
+func TestHistory_Retention(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.RetainVersions = VersionRetention{Count: 1}
+	})
+	defer db.Close()
+	for _, v := range []string{"a", "b", "c"} {
+		require.NoError(t, db.Set([]byte("k"), []byte(v)))
+	}
+	revs, err := db.History([]byte("k"))
+	require.NoError(t, err)
+	require.Len(t, revs, 2)
+	assert.Equal(t, "b", string(revs[0].Value))
+
+	aged := newTestDB(t, func(o *Options) {
+		o.RetainVersions = VersionRetention{Age: time.Hour}
+	})
+	defer aged.Close()
+	now := time.Now()
+	setAt(t, aged, "k", "a", now.Add(-3*time.Hour).Unix())
+	setAt(t, aged, "k", "b", now.Add(-2*time.Hour).Unix())
+	setAt(t, aged, "k", "c", now.Unix())
+	revs, err = aged.History([]byte("k"))
+	require.NoError(t, err)
+	require.Len(t, revs, 2, "a was superseded more than an hour ago")
+	assert.Equal(t, "b", string(revs[0].Value))
+}
+
+func TestHistory_Disabled(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("k"), []byte("a")))
+	require.NoError(t, db.Set([]byte("k"), []byte("b")))
+	revs, err := db.History([]byte("k"))
+	require.NoError(t, err)
+	require.Len(t, revs, 1)
+	got, err := db.GetAt([]byte("k"), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(got))
+	_, err = db.GetAt([]byte("k"), time.Now().Add(-time.Hour))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+
+	_, err = NewDB(&Options{Dir: t.TempDir() + "/x", KeydirOnDisk: true, RetainVersions: VersionRetention{Count: 1}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,664 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	DiskIndexFileName = "keydir.idx"
+	DiskKeysFileName  = "keydir.keys"
+
+	// DefaultDiskCacheBytes bounds the slot page cache when no size is given.
+	DefaultDiskCacheBytes = 64 << 20
+
+	diskMagic      = "TBKD"
+	diskVersion    = byte(2)
+	diskPageSize   = 4096
+	diskHeaderSize = diskPageSize
+	diskSlotSize   = 64
+	slotsPerPage   = diskPageSize / diskSlotSize
+	minDiskSlots   = 1024
+	maxLoadPercent = 70
+	minCachedPages = 4
+)
+
+const (
+	slotEmpty   = byte(0)
+	slotLive    = byte(1)
+	slotDeleted = byte(2)
+)
+
+var (
+	ErrInvalidDiskIndex = errors.New("index: invalid or unsupported on-disk keydir")
+)
+
+// DiskKeyDir is an Index kept in an open-addressing hash table on disk
+// (keydir.idx) with keys in an append-only side file (keydir.keys). At most
+// cacheBytes of 4 KiB slot pages are held at once: memory-mapped windows where
+// the platform supports it, a pread/pwrite LRU of pages elsewhere. Either way
+// key count is limited by disk rather than RAM; each lookup costs a page probe
+// plus one key read.
+//
+// The header records whether the files were flushed cleanly and which segment
+// position they cover. The first mutation after open or Flush clears that flag
+// (with fsync) before any page can reach disk, so a crash always leaves either
+// a consistent clean index or one that recovery knows to rebuild.
+type DiskKeyDir struct {
+	mu sync.Mutex
+
+	dir  string
+	idx  *os.File
+	keys *os.File
+
+	capacity uint64
+	live     uint64
+	used     uint64 // live + deleted slots
+	keysEnd  int64
+
+	clean      bool
+	coveredFid int
+	coveredOff int64
+	coveredSeq uint64
+	wasClean   bool
+
+	mmap     bool
+	maxPages int       // bound on slot pages held, mapped or cached
+	pages    slotPages // nil until the table size is known
+
+	err error
+}
+
+// diskSlot is the decoded form of one 64-byte slot:
+// [0] state, [4:8] key size, [8:16] hash, [16:24] key offset, [24:28] fid,
+// [28:32] value size, [32:40] record offset, [40:48] timestamp, [48:56] expiry,
+// [56:64] seq.
+type diskSlot struct {
+	state     byte
+	keySize   uint32
+	hash      uint64
+	keyOff    int64
+	fid       int
+	off       int64
+	ts        uint64
+	valueSize uint32
+	expiry    uint64
+	seq       uint64
+}
+
+// OpenDiskKeyDir opens (or creates) the on-disk keydir in dir, holding at
+// most cacheBytes of slot pages, mapped where supported and cached otherwise;
+// cacheBytes <= 0 means DefaultDiskCacheBytes.
+func OpenDiskKeyDir(dir string, cacheBytes int64) (*DiskKeyDir, error) {
+	return openDiskKeyDir(dir, cacheBytes, mmapSupported)
+}
+
+func openDiskKeyDir(dir string, cacheBytes int64, mmap bool) (*DiskKeyDir, error) {
+	if cacheBytes <= 0 {
+		cacheBytes = DefaultDiskCacheBytes
+	}
+	dk := &DiskKeyDir{
+		dir:      dir,
+		mmap:     mmap,
+		maxPages: int(cacheBytes / diskPageSize),
+	}
+	if dk.maxPages < minCachedPages {
+		dk.maxPages = minCachedPages
+	}
+	if err := removeTemp(dir); err != nil {
+		return nil, err
+	}
+
+	var err error
+	dk.idx, err = os.OpenFile(filepath.Join(dir, DiskIndexFileName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	dk.keys, err = os.OpenFile(filepath.Join(dir, DiskKeysFileName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		dk.idx.Close()
+		return nil, err
+	}
+	err = dk.readHeader()
+	if err == nil {
+		dk.pages, err = openSlotPages(dk.idx, dk.capacity, dk.mmap, dk.maxPages)
+	}
+	if err != nil {
+		if err := dk.Reset(); err != nil {
+			dk.Close()
+			return nil, err
+		}
+	}
+	dk.wasClean = dk.clean
+	return dk, nil
+}
+
+// RemoveDiskKeyDir deletes the on-disk keydir files in dir if present.
+func RemoveDiskKeyDir(dir string) error {
+	for _, name := range []string{DiskIndexFileName, DiskKeysFileName} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Covered implements Persistent.
+func (dk *DiskKeyDir) Covered() (fid int, off int64, seq uint64, ok bool) {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	return dk.coveredFid, dk.coveredOff, dk.coveredSeq, dk.wasClean
+}
+
+// Reset empties the index, leaving it dirty until the next Flush.
+func (dk *DiskKeyDir) Reset() error {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	return dk.reset(minDiskSlots)
+}
+
+func (dk *DiskKeyDir) reset(capacity uint64) error {
+	if err := dk.closePages(); err != nil {
+		return err
+	}
+	if err := dk.idx.Truncate(0); err != nil {
+		return err
+	}
+	if err := dk.idx.Truncate(diskHeaderSize + int64(capacity)*diskSlotSize); err != nil {
+		return err
+	}
+	pages, err := openSlotPages(dk.idx, capacity, dk.mmap, dk.maxPages)
+	if err != nil {
+		return err
+	}
+	dk.pages = pages
+	if err := dk.keys.Truncate(0); err != nil {
+		return err
+	}
+	dk.capacity = capacity
+	dk.live, dk.used, dk.keysEnd = 0, 0, 0
+	dk.err = nil
+	dk.coveredFid, dk.coveredOff, dk.coveredSeq = 0, 0, 0
+	dk.clean, dk.wasClean = true, false
+	return dk.markDirty()
+}
+
+// Flush writes every dirty page and a clean header covering segment position
+// (fid, off) and sequence numbers up to seq, then fsyncs both files.
+func (dk *DiskKeyDir) Flush(fid int, off int64, seq uint64) error {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	if err := dk.pages.writeBack(); err != nil {
+		return err
+	}
+	if err := dk.keys.Sync(); err != nil {
+		return err
+	}
+	if err := dk.idx.Sync(); err != nil {
+		return err
+	}
+	dk.clean = true
+	dk.coveredFid, dk.coveredOff, dk.coveredSeq = fid, off, seq
+	if err := dk.writeHeader(); err != nil {
+		return err
+	}
+	return dk.idx.Sync()
+}
+
+// Close releases the files without flushing; call Flush first for a clean shutdown.
+func (dk *DiskKeyDir) Close() error {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	first := dk.closePages()
+	if dk.idx != nil {
+		if err := dk.idx.Close(); err != nil && first == nil {
+			first = err
+		}
+		dk.idx = nil
+	}
+	if dk.keys != nil {
+		if err := dk.keys.Close(); err != nil && first == nil {
+			first = err
+		}
+		dk.keys = nil
+	}
+	return first
+}
+
+// closePages unmaps or drops the slot pages, which must not outlive the
+// current size of keydir.idx.
+func (dk *DiskKeyDir) closePages() error {
+	if dk.pages == nil {
+		return nil
+	}
+	err := dk.pages.close()
+	dk.pages = nil
+	return err
+}
+
+func (dk *DiskKeyDir) Find(key string) *DataPosition {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	_, s, found, err := dk.lookup(key)
+	if err != nil {
+		dk.fail(err)
+		return nil
+	}
+	if !found {
+		return nil
+	}
+	return s.position()
+}
+
+func (dk *DiskKeyDir) Add(key string, dp *DataPosition) {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	dk.fail(dk.add(key, dp))
+}
+
+func (dk *DiskKeyDir) Update(key string, dp *DataPosition) {
+	dk.Add(key, dp)
+}
+
+func (dk *DiskKeyDir) Delete(key string) {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	i, s, found, err := dk.lookup(key)
+	if err != nil || !found {
+		dk.fail(err)
+		return
+	}
+	if err := dk.markDirty(); err != nil {
+		dk.fail(err)
+		return
+	}
+	s.state = slotDeleted
+	if err := dk.putSlot(i, s); err != nil {
+		dk.fail(err)
+		return
+	}
+	dk.live--
+}
+
+// Err returns the first I/O error hit by Find/Add/Delete/Range, which have no
+// error result. After an error the index is unreliable and must be rebuilt.
+func (dk *DiskKeyDir) Err() error {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	return dk.err
+}
+
+func (dk *DiskKeyDir) fail(err error) {
+	if err != nil && dk.err == nil {
+		dk.err = err
+	}
+}
+
+func (dk *DiskKeyDir) Len() int {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	return int(dk.live)
+}
+
+// Bytes reports the memory the slot pages hold: the mapped windows or the
+// cached pages, never more than the cacheBytes bound.
+func (dk *DiskKeyDir) Bytes() int64 {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	if dk.pages == nil {
+		return 0
+	}
+	return dk.pages.bytes()
+}
+
+// Range visits every key in slot order until fn returns false. fn must not
+// modify the DiskKeyDir.
+func (dk *DiskKeyDir) Range(fn func(key string, dp *DataPosition) bool) {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	dk.fail(dk.rangeSlots(func(_ uint64, s diskSlot, key string) bool {
+		return fn(key, s.position())
+	}))
+}
+
+// Walk implements Walker, reading at most n keys per chunk so a full pass
+// never holds the whole key set. Unlike Ascend it needs no scratch space.
+func (dk *DiskKeyDir) Walk(n int, fn func(keys []string) bool) error {
+	for next := uint64(0); ; {
+		keys, done, err := dk.walkChunk(&next, n)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 && !fn(keys) {
+			return nil
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// walkChunk collects up to n live keys starting at slot *next and advances it.
+func (dk *DiskKeyDir) walkChunk(next *uint64, n int) ([]string, bool, error) {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	keys := make([]string, 0, n)
+	for ; *next < dk.capacity && len(keys) < n; *next++ {
+		s, err := dk.slot(*next)
+		if err != nil {
+			return nil, false, err
+		}
+		if s.state != slotLive {
+			continue
+		}
+		key, err := dk.readKey(s)
+		if err != nil {
+			return nil, false, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, *next >= dk.capacity, nil
+}
+
+func (dk *DiskKeyDir) add(key string, dp *DataPosition) error {
+	if err := dk.markDirty(); err != nil {
+		return err
+	}
+	h := hashKey(key)
+	mask := dk.capacity - 1
+	i := h & mask
+	var (
+		free      uint64
+		haveFree  bool
+		freeState byte
+	)
+	for n := uint64(0); n < dk.capacity; n++ {
+		s, err := dk.slot(i)
+		if err != nil {
+			return err
+		}
+		if s.state == slotEmpty {
+			if !haveFree {
+				free, haveFree, freeState = i, true, slotEmpty
+			}
+			break
+		}
+		if s.state == slotDeleted {
+			if !haveFree {
+				free, haveFree, freeState = i, true, slotDeleted
+			}
+		} else if s.hash == h && int(s.keySize) == len(key) {
+			k, err := dk.readKey(s)
+			if err != nil {
+				return err
+			}
+			if k == key {
+				s.setPosition(dp)
+				return dk.putSlot(i, s)
+			}
+		}
+		i = (i + 1) & mask
+	}
+	if !haveFree {
+		return errors.New("index: on-disk keydir is full")
+	}
+
+	keyOff := dk.keysEnd
+	if _, err := dk.keys.WriteAt([]byte(key), keyOff); err != nil {
+		return err
+	}
+	dk.keysEnd += int64(len(key))
+	s := diskSlot{state: slotLive, keySize: uint32(len(key)), hash: h, keyOff: keyOff}
+	s.setPosition(dp)
+	if err := dk.putSlot(free, s); err != nil {
+		return err
+	}
+	dk.live++
+	if freeState == slotEmpty {
+		dk.used++
+	}
+	if dk.used*100 > dk.capacity*maxLoadPercent {
+		return dk.rehash()
+	}
+	return nil
+}
+
+// lookup probes for key and returns its slot index and contents.
+func (dk *DiskKeyDir) lookup(key string) (uint64, diskSlot, bool, error) {
+	h := hashKey(key)
+	mask := dk.capacity - 1
+	i := h & mask
+	for n := uint64(0); n < dk.capacity; n++ {
+		s, err := dk.slot(i)
+		if err != nil {
+			return 0, diskSlot{}, false, err
+		}
+		if s.state == slotEmpty {
+			return 0, diskSlot{}, false, nil
+		}
+		if s.state == slotLive && s.hash == h && int(s.keySize) == len(key) {
+			k, err := dk.readKey(s)
+			if err != nil {
+				return 0, diskSlot{}, false, err
+			}
+			if k == key {
+				return i, s, true, nil
+			}
+		}
+		i = (i + 1) & mask
+	}
+	return 0, diskSlot{}, false, nil
+}
+
+// rehash rebuilds the table (and compacts the key file) into fresh files,
+// doubling capacity unless most used slots are only tombstones.
+func (dk *DiskKeyDir) rehash() error {
+	capacity := dk.capacity
+	if dk.live*100 > capacity*maxLoadPercent/2 {
+		capacity *= 2
+	}
+	tmpDir, err := os.MkdirTemp(dk.dir, tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	next, err := openDiskKeyDir(tmpDir, int64(dk.maxPages)*diskPageSize, dk.mmap)
+	if err != nil {
+		return err
+	}
+	if err := next.reset(capacity); err != nil {
+		next.Close()
+		return err
+	}
+	err = dk.rangeSlots(func(_ uint64, s diskSlot, key string) bool {
+		return next.add(key, s.position()) == nil
+	})
+	if err == nil && next.live != dk.live {
+		err = errors.New("index: on-disk keydir rehash lost keys")
+	}
+	if err == nil {
+		err = next.pages.writeBack()
+	}
+	if err == nil {
+		err = next.writeHeader()
+	}
+	if err != nil {
+		next.Close()
+		return err
+	}
+	if err := os.Rename(filepath.Join(tmpDir, DiskIndexFileName), filepath.Join(dk.dir, DiskIndexFileName)); err != nil {
+		next.Close()
+		return err
+	}
+	if err := os.Rename(filepath.Join(tmpDir, DiskKeysFileName), filepath.Join(dk.dir, DiskKeysFileName)); err != nil {
+		next.Close()
+		return err
+	}
+	dk.closePages()
+	dk.idx.Close()
+	dk.keys.Close()
+	dk.idx, dk.keys = next.idx, next.keys
+	dk.capacity, dk.live, dk.used, dk.keysEnd = next.capacity, next.live, next.used, next.keysEnd
+	dk.pages = next.pages
+	dk.clean = false
+	return nil
+}
+
+// rangeSlots visits live slots in table order, stopping early when fn returns false.
+func (dk *DiskKeyDir) rangeSlots(fn func(i uint64, s diskSlot, key string) bool) error {
+	for i := uint64(0); i < dk.capacity; i++ {
+		s, err := dk.slot(i)
+		if err != nil {
+			return err
+		}
+		if s.state != slotLive {
+			continue
+		}
+		key, err := dk.readKey(s)
+		if err != nil {
+			return err
+		}
+		if !fn(i, s, key) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (dk *DiskKeyDir) slot(i uint64) (diskSlot, error) {
+	page, err := dk.pages.page(i / slotsPerPage)
+	if err != nil {
+		return diskSlot{}, err
+	}
+	b := page[(i%slotsPerPage)*diskSlotSize:][:diskSlotSize]
+	return diskSlot{
+		state:     b[0],
+		keySize:   binary.LittleEndian.Uint32(b[4:8]),
+		hash:      binary.LittleEndian.Uint64(b[8:16]),
+		keyOff:    int64(binary.LittleEndian.Uint64(b[16:24])),
+		fid:       int(binary.LittleEndian.Uint32(b[24:28])),
+		valueSize: binary.LittleEndian.Uint32(b[28:32]),
+		off:       int64(binary.LittleEndian.Uint64(b[32:40])),
+		ts:        binary.LittleEndian.Uint64(b[40:48]),
+		expiry:    binary.LittleEndian.Uint64(b[48:56]),
+		seq:       binary.LittleEndian.Uint64(b[56:64]),
+	}, nil
+}
+
+func (dk *DiskKeyDir) putSlot(i uint64, s diskSlot) error {
+	page, err := dk.pages.page(i / slotsPerPage)
+	if err != nil {
+		return err
+	}
+	b := page[(i%slotsPerPage)*diskSlotSize:][:diskSlotSize]
+	b[0] = s.state
+	binary.LittleEndian.PutUint32(b[4:8], s.keySize)
+	binary.LittleEndian.PutUint64(b[8:16], s.hash)
+	binary.LittleEndian.PutUint64(b[16:24], uint64(s.keyOff))
+	binary.LittleEndian.PutUint32(b[24:28], uint32(s.fid))
+	binary.LittleEndian.PutUint32(b[28:32], s.valueSize)
+	binary.LittleEndian.PutUint64(b[32:40], uint64(s.off))
+	binary.LittleEndian.PutUint64(b[40:48], s.ts)
+	binary.LittleEndian.PutUint64(b[48:56], s.expiry)
+	binary.LittleEndian.PutUint64(b[56:64], s.seq)
+	dk.pages.dirty(i / slotsPerPage)
+	return nil
+}
+
+func (s *diskSlot) position() *DataPosition {
+	return &DataPosition{
+		Fid:       s.fid,
+		Off:       s.off,
+		Timestamp: s.ts,
+		KeySize:   int(s.keySize),
+		ValueSize: int(s.valueSize),
+		Expiry:    s.expiry,
+		Seq:       s.seq,
+	}
+}
+
+func (s *diskSlot) setPosition(dp *DataPosition) {
+	s.fid = dp.Fid
+	s.off = dp.Off
+	s.ts = dp.Timestamp
+	s.valueSize = uint32(dp.ValueSize)
+	s.expiry = dp.Expiry
+	s.seq = dp.Seq
+}
+
+func (dk *DiskKeyDir) readKey(s diskSlot) (string, error) {
+	buf := make([]byte, s.keySize)
+	if _, err := dk.keys.ReadAt(buf, s.keyOff); err != nil && len(buf) > 0 {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// markDirty durably clears the clean flag before the first change after open
+// or Flush, so no modified page can reach disk under a clean header.
+func (dk *DiskKeyDir) markDirty() error {
+	if !dk.clean {
+		return nil
+	}
+	dk.clean = false
+	if err := dk.writeHeader(); err != nil {
+		return err
+	}
+	return dk.idx.Sync()
+}
+
+func (dk *DiskKeyDir) writeHeader() error {
+	h := make([]byte, diskHeaderSize)
+	copy(h[0:4], diskMagic)
+	h[4] = diskVersion
+	if dk.clean {
+		h[5] = 1
+	}
+	binary.LittleEndian.PutUint64(h[8:16], dk.capacity)
+	binary.LittleEndian.PutUint64(h[16:24], dk.live)
+	binary.LittleEndian.PutUint64(h[24:32], dk.used)
+	binary.LittleEndian.PutUint64(h[32:40], uint64(dk.keysEnd))
+	binary.LittleEndian.PutUint64(h[40:48], uint64(dk.coveredFid))
+	binary.LittleEndian.PutUint64(h[48:56], uint64(dk.coveredOff))
+	binary.LittleEndian.PutUint64(h[56:64], dk.coveredSeq)
+	_, err := dk.idx.WriteAt(h, 0)
+	return err
+}
+
+func (dk *DiskKeyDir) readHeader() error {
+	h := make([]byte, diskHeaderSize)
+	if _, err := io.ReadFull(io.NewSectionReader(dk.idx, 0, diskHeaderSize), h); err != nil {
+		return ErrInvalidDiskIndex
+	}
+	if string(h[0:4]) != diskMagic || h[4] != diskVersion {
+		return ErrInvalidDiskIndex
+	}
+	dk.clean = h[5] == 1
+	dk.capacity = binary.LittleEndian.Uint64(h[8:16])
+	dk.live = binary.LittleEndian.Uint64(h[16:24])
+	dk.used = binary.LittleEndian.Uint64(h[24:32])
+	dk.keysEnd = int64(binary.LittleEndian.Uint64(h[32:40]))
+	dk.coveredFid = int(binary.LittleEndian.Uint64(h[40:48]))
+	dk.coveredOff = int64(binary.LittleEndian.Uint64(h[48:56]))
+	dk.coveredSeq = binary.LittleEndian.Uint64(h[56:64])
+	if dk.capacity < minDiskSlots || dk.capacity&(dk.capacity-1) != 0 || dk.used > dk.capacity || dk.live > dk.used {
+		return ErrInvalidDiskIndex
+	}
+	// A short table would fault when mapped; treat it as corrupt.
+	st, err := dk.idx.Stat()
+	if err != nil || st.Size() < diskHeaderSize+int64(dk.capacity)*diskSlotSize {
+		return ErrInvalidDiskIndex
+	}
+	return nil
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
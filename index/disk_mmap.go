@@ -0,0 +1,121 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package index
+
+import (
+	"container/list"
+	"os"
+	"syscall"
+)
+
+// mmapSupported selects mappedPages for the on-disk keydir on this platform.
+const mmapSupported = true
+
+// mapWindowPages is the most slot pages one mapped window covers.
+const mapWindowPages = 256
+
+// mappedPages maps the slot region of keydir.idx shared and read-write in
+// windows of up to mapWindowPages pages, keeping at most maxWindows mapped and
+// unmapping the least recently used one to make room, so the mapping never
+// exceeds the page cache bound. Stores land in the file's page cache and
+// survive the unmap; the fsync in Flush makes them durable. The file must not
+// shrink while mapped, so the keydir unmaps before truncating it.
+type mappedPages struct {
+	fd          int
+	pages       uint64 // slot pages in the table
+	windowPages uint64
+	maxWindows  int
+	windows     map[uint64]*mapWindow
+	lru         *list.List
+}
+
+// mapWindow is one mapping: mem as mapped (from an OS page boundary) and data,
+// the slot pages it covers.
+type mapWindow struct {
+	no   uint64
+	mem  []byte
+	data []byte
+	elem *list.Element
+}
+
+func mapPages(idx *os.File, capacity uint64, maxPages int) (slotPages, error) {
+	windowPages := uint64(min(mapWindowPages, maxPages))
+	return &mappedPages{
+		fd:          int(idx.Fd()),
+		pages:       capacity * diskSlotSize / diskPageSize,
+		windowPages: windowPages,
+		maxWindows:  max(1, maxPages/int(windowPages)),
+		windows:     map[uint64]*mapWindow{},
+		lru:         list.New(),
+	}, nil
+}
+
+// page returns slot page no from its window, mapping the window (and
+// unmapping the least recently used one) on a miss. The page is only valid
+// until the next call.
+func (m *mappedPages) page(no uint64) ([]byte, error) {
+	wno := no / m.windowPages
+	w, ok := m.windows[wno]
+	if ok {
+		m.lru.MoveToFront(w.elem)
+	} else {
+		for len(m.windows) >= m.maxWindows {
+			if err := m.unmap(m.lru.Back().Value.(*mapWindow)); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if w, err = m.mapWindow(wno); err != nil {
+			return nil, err
+		}
+	}
+	return w.data[(no-wno*m.windowPages)*diskPageSize:][:diskPageSize], nil
+}
+
+func (m *mappedPages) mapWindow(wno uint64) (*mapWindow, error) {
+	first := wno * m.windowPages
+	n := min(m.windowPages, m.pages-first)
+	off := int64(diskHeaderSize + first*diskPageSize)
+	base := off &^ int64(os.Getpagesize()-1)
+	mem, err := syscall.Mmap(m.fd, base, int(off-base)+int(n*diskPageSize),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	w := &mapWindow{no: wno, mem: mem, data: mem[off-base:]}
+	w.elem = m.lru.PushFront(w)
+	m.windows[wno] = w
+	return w, nil
+}
+
+func (m *mappedPages) unmap(w *mapWindow) error {
+	m.lru.Remove(w.elem)
+	delete(m.windows, w.no)
+	return syscall.Munmap(w.mem)
+}
+
+func (m *mappedPages) dirty(uint64) {}
+
+func (m *mappedPages) writeBack() error {
+	return nil
+}
+
+// bytes reports the mapped size, an upper bound on what the kernel keeps
+// resident for the table.
+func (m *mappedPages) bytes() int64 {
+	var n int64
+	for _, w := range m.windows {
+		n += int64(len(w.data))
+	}
+	return n
+}
+
+func (m *mappedPages) close() error {
+	var first error
+	for m.lru.Len() > 0 {
+		if err := m.unmap(m.lru.Back().Value.(*mapWindow)); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package index
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapSupported is false here; the on-disk keydir uses pageCache instead.
+const mmapSupported = false
+
+func mapPages(*os.File, uint64, int) (slotPages, error) {
+	return nil, errors.New("index: mmap is not supported on this platform")
+}
@@ -0,0 +1,109 @@
+package index
+
+import (
+	"container/list"
+	"io"
+	"os"
+)
+
+// slotPages holds the slot region of keydir.idx, everything after the header,
+// as 4 KiB pages. Where mmap is available the region is mapped a window at a
+// time (mappedPages); elsewhere pages are read and written with pread/pwrite
+// (pageCache). Either way at most maxPages pages are held at once.
+type slotPages interface {
+	// page returns slot page no; the caller may modify it and then calls dirty.
+	page(no uint64) ([]byte, error)
+	dirty(no uint64)
+	// writeBack hands every modified page to the file; the caller fsyncs.
+	writeBack() error
+	// bytes is the memory the pages may hold.
+	bytes() int64
+	close() error
+}
+
+// openSlotPages gives access to the capacity slots of idx, holding at most
+// maxPages pages: mapped windows when mmap is set, otherwise a page cache.
+func openSlotPages(idx *os.File, capacity uint64, mmap bool, maxPages int) (slotPages, error) {
+	if mmap {
+		return mapPages(idx, capacity, maxPages)
+	}
+	return &pageCache{idx: idx, maxPages: maxPages, pages: map[uint64]*diskPage{}, lru: list.New()}, nil
+}
+
+type diskPage struct {
+	no    uint64
+	buf   []byte
+	dirty bool
+	elem  *list.Element
+}
+
+// pageCache is the pread/pwrite slotPages: a page is read on a miss and
+// written back when it is evicted or on writeBack.
+type pageCache struct {
+	idx      *os.File
+	maxPages int
+	pages    map[uint64]*diskPage
+	lru      *list.List
+}
+
+// page returns slot page no from the cache, reading it (and evicting the least
+// recently used page) on a miss.
+func (c *pageCache) page(no uint64) ([]byte, error) {
+	if p, ok := c.pages[no]; ok {
+		c.lru.MoveToFront(p.elem)
+		return p.buf, nil
+	}
+	for len(c.pages) >= c.maxPages {
+		victim := c.lru.Back().Value.(*diskPage)
+		if err := c.writePage(victim); err != nil {
+			return nil, err
+		}
+		c.lru.Remove(victim.elem)
+		delete(c.pages, victim.no)
+	}
+	p := &diskPage{no: no, buf: make([]byte, diskPageSize)}
+	n, err := c.idx.ReadAt(p.buf, diskHeaderSize+int64(no)*diskPageSize)
+	if err != nil && !(err == io.EOF && n == 0) {
+		return nil, err
+	}
+	p.elem = c.lru.PushFront(p)
+	c.pages[no] = p
+	return p.buf, nil
+}
+
+func (c *pageCache) dirty(no uint64) {
+	if p, ok := c.pages[no]; ok {
+		p.dirty = true
+	}
+}
+
+func (c *pageCache) writeBack() error {
+	for _, p := range c.pages {
+		if err := c.writePage(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *pageCache) writePage(p *diskPage) error {
+	if !p.dirty {
+		return nil
+	}
+	if _, err := c.idx.WriteAt(p.buf, diskHeaderSize+int64(p.no)*diskPageSize); err != nil {
+		return err
+	}
+	p.dirty = false
+	return nil
+}
+
+func (c *pageCache) bytes() int64 {
+	return int64(len(c.pages)) * diskPageSize
+}
+
+// close drops the cached pages without writing them back.
+func (c *pageCache) close() error {
+	c.pages = map[uint64]*diskPage{}
+	c.lru.Init()
+	return nil
+}
@@ -0,0 +1,257 @@
+package index
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// tempPrefix names the scratch files and directories the on-disk keydir
+// creates next to its table; a crash can leave them behind, so opening the
+// keydir removes any it finds.
+const tempPrefix = "keydir-tmp-"
+
+// sortBufferKeys is how many keys DiskKeyDir.Ascend sorts in memory before
+// spilling them to a run file. A variable so tests can force spills.
+var sortBufferKeys = 1 << 16
+
+// entryHeaderSize is the fixed part of an encoded entry: key length, fid,
+// record offset, timestamp, key size, value size, expiry and seq.
+const entryHeaderSize = 4 + 8 + 8 + 8 + 4 + 4 + 8 + 8
+
+// sortEntry is one key and its position, as sorted and spilled by Ascend and
+// frozen by Run.
+type sortEntry struct {
+	key string
+	dp  DataPosition
+}
+
+// writeEntry appends e to w and returns the bytes written.
+func writeEntry(w *bufio.Writer, e *sortEntry) (int, error) {
+	var h [entryHeaderSize]byte
+	binary.LittleEndian.PutUint32(h[0:4], uint32(len(e.key)))
+	binary.LittleEndian.PutUint64(h[4:12], uint64(e.dp.Fid))
+	binary.LittleEndian.PutUint64(h[12:20], uint64(e.dp.Off))
+	binary.LittleEndian.PutUint64(h[20:28], e.dp.Timestamp)
+	binary.LittleEndian.PutUint32(h[28:32], uint32(e.dp.KeySize))
+	binary.LittleEndian.PutUint32(h[32:36], uint32(e.dp.ValueSize))
+	binary.LittleEndian.PutUint64(h[36:44], e.dp.Expiry)
+	binary.LittleEndian.PutUint64(h[44:52], e.dp.Seq)
+	if _, err := w.Write(h[:]); err != nil {
+		return 0, err
+	}
+	if _, err := w.WriteString(e.key); err != nil {
+		return 0, err
+	}
+	return entryHeaderSize + len(e.key), nil
+}
+
+// readEntry decodes the next entry from r; io.EOF means there is none.
+func readEntry(r *bufio.Reader) (sortEntry, error) {
+	var h [entryHeaderSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return sortEntry{}, err
+	}
+	key := make([]byte, binary.LittleEndian.Uint32(h[0:4]))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return sortEntry{}, io.ErrUnexpectedEOF
+	}
+	return sortEntry{key: string(key), dp: DataPosition{
+		Fid:       int(binary.LittleEndian.Uint64(h[4:12])),
+		Off:       int64(binary.LittleEndian.Uint64(h[12:20])),
+		Timestamp: binary.LittleEndian.Uint64(h[20:28]),
+		KeySize:   int(binary.LittleEndian.Uint32(h[28:32])),
+		ValueSize: int(binary.LittleEndian.Uint32(h[32:36])),
+		Expiry:    binary.LittleEndian.Uint64(h[36:44]),
+		Seq:       binary.LittleEndian.Uint64(h[44:52]),
+	}}, nil
+}
+
+// removeTemp deletes scratch files a crashed process left in dir.
+func removeTemp(dir string) error {
+	names, err := filepath.Glob(filepath.Join(dir, tempPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.RemoveAll(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ascend implements Index. One pass over the table under the lock gathers the
+// keys in [start, end), sorting them sortBufferKeys at a time and spilling
+// each full batch to a run file in a scratch directory; the runs are then
+// merged into fn with the lock released. Memory stays bounded whatever the
+// key count, fn may call back into dk, and it sees the keys as they were when
+// Ascend started.
+func (dk *DiskKeyDir) Ascend(start, end string, fn func(key string, dp *DataPosition) bool) error {
+	var tmp string
+	defer func() {
+		if tmp != "" {
+			os.RemoveAll(tmp)
+		}
+	}()
+	buf, runs, err := dk.sortRuns(start, end, &tmp)
+	if err != nil {
+		return err
+	}
+	return mergeRuns(buf, runs, fn)
+}
+
+// sortRuns collects the keys in [start, end) and returns the last, sorted
+// batch plus the paths of the batches spilled before it, creating the scratch
+// directory *tmp on the first spill.
+func (dk *DiskKeyDir) sortRuns(start, end string, tmp *string) ([]sortEntry, []string, error) {
+	dk.mu.Lock()
+	defer dk.mu.Unlock()
+	var (
+		buf  []sortEntry
+		runs []string
+		werr error
+	)
+	err := dk.rangeSlots(func(_ uint64, s diskSlot, key string) bool {
+		if key < start || (end != "" && key >= end) {
+			return true
+		}
+		buf = append(buf, sortEntry{key: key, dp: *s.position()})
+		if len(buf) < sortBufferKeys {
+			return true
+		}
+		if *tmp == "" {
+			if *tmp, werr = os.MkdirTemp(dk.dir, tempPrefix); werr != nil {
+				return false
+			}
+		}
+		var path string
+		if path, werr = spillRun(*tmp, buf); werr != nil {
+			return false
+		}
+		runs = append(runs, path)
+		buf = buf[:0]
+		return true
+	})
+	if err == nil {
+		err = werr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	sortEntries(buf)
+	return buf, runs, nil
+}
+
+func sortEntries(es []sortEntry) {
+	sort.Slice(es, func(i, j int) bool { return es[i].key < es[j].key })
+}
+
+// spillRun sorts es and writes it to a new file in dir.
+func spillRun(dir string, es []sortEntry) (string, error) {
+	sortEntries(es)
+	f, err := os.CreateTemp(dir, "run-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for i := range es {
+		if _, err := writeEntry(w, &es[i]); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// mergeSource is one sorted input to mergeRuns: a spilled run file, or the
+// last batch still in memory when r is nil.
+type mergeSource struct {
+	r    *bufio.Reader
+	mem  []sortEntry
+	head sortEntry
+}
+
+// advance loads the source's next entry into head, reporting false at the end.
+func (src *mergeSource) advance() (bool, error) {
+	if src.r == nil {
+		if len(src.mem) == 0 {
+			return false, nil
+		}
+		src.head, src.mem = src.mem[0], src.mem[1:]
+		return true, nil
+	}
+	e, err := readEntry(src.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	src.head = e
+	return true, nil
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return h[i].head.key < h[j].head.key }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return src
+}
+
+// mergeRuns calls fn for every entry of buf and the run files in key order
+// until fn returns false. Keys are unique across the inputs.
+func mergeRuns(buf []sortEntry, runs []string, fn func(key string, dp *DataPosition) bool) error {
+	h := make(mergeHeap, 0, len(runs)+1)
+	for _, path := range runs {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h = append(h, &mergeSource{r: bufio.NewReaderSize(f, 16<<10)})
+	}
+	h = append(h, &mergeSource{mem: buf})
+	live := h[:0]
+	for _, src := range h {
+		ok, err := src.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			live = append(live, src)
+		}
+	}
+	h = live
+	heap.Init(&h)
+	for h.Len() > 0 {
+		src := h[0]
+		dp := src.head.dp
+		if !fn(src.head.key, &dp) {
+			return nil
+		}
+		ok, err := src.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskKeyDir_AddFindDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		keys       int
+		cacheBytes int64
+		mmap       bool
+	}{
+		{name: "fits_in_cache", keys: 100, cacheBytes: 0},
+		{name: "rehash_and_evict", keys: 5000, cacheBytes: 4 * diskPageSize},
+		{name: "mapped_rehash", keys: 5000, mmap: true},
+		{name: "mapped_windows_bounded", keys: 20000, cacheBytes: 8 * diskPageSize, mmap: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.mmap && !mmapSupported {
+				t.Skip("no mmap on this platform")
+			}
+			dk, err := openDiskKeyDir(t.TempDir(), tt.cacheBytes, tt.mmap)
+			require.NoError(t, err)
+			defer dk.Close()
+
+			for i := 0; i < tt.keys; i++ {
+				dk.Add(fmt.Sprintf("key_%d", i), &DataPosition{Fid: 1, Off: int64(i), KeySize: 5, ValueSize: i, Timestamp: uint64(i)})
+			}
+			for i := 0; i < tt.keys; i += 2 {
+				dk.Delete(fmt.Sprintf("key_%d", i))
+			}
+			dk.Update("key_1", &DataPosition{Fid: 2, Off: 7, ValueSize: 3})
+			require.NoError(t, dk.Err())
+
+			assert.Equal(t, tt.keys/2, dk.Len())
+			assert.Nil(t, dk.Find("key_0"))
+			assert.Nil(t, dk.Find("missing"))
+			dp := dk.Find("key_1")
+			require.NotNil(t, dp)
+			assert.Equal(t, 2, dp.Fid)
+			assert.Equal(t, int64(7), dp.Off)
+			dp = dk.Find(fmt.Sprintf("key_%d", tt.keys-1))
+			require.NotNil(t, dp)
+			assert.Equal(t, tt.keys-1, dp.ValueSize)
+
+			var keys []string
+			require.NoError(t, dk.Ascend("", "", func(key string, _ *DataPosition) bool {
+				keys = append(keys, key)
+				return true
+			}))
+			assert.Len(t, keys, tt.keys/2)
+			assert.True(t, sort.StringsAreSorted(keys))
+			if tt.cacheBytes > 0 {
+				assert.Greater(t, dk.Bytes(), int64(0))
+				assert.LessOrEqual(t, dk.Bytes(), tt.cacheBytes)
+			}
+		})
+	}
+}
+
+func TestDiskKeyDir_Walk(t *testing.T) {
+	dk, err := OpenDiskKeyDir(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer dk.Close()
+	for i := 0; i < 2500; i++ {
+		dk.Add(fmt.Sprintf("key-%04d", i), &DataPosition{Fid: 1, Off: int64(i)})
+	}
+	dk.Delete("key-0042")
+
+	seen := map[string]bool{}
+	chunks := 0
+	require.NoError(t, dk.Walk(1000, func(keys []string) bool {
+		assert.LessOrEqual(t, len(keys), 1000)
+		for _, k := range keys {
+			seen[k] = true
+		}
+		chunks++
+		return true
+	}))
+	assert.Len(t, seen, 2499)
+	assert.False(t, seen["key-0042"])
+	assert.Equal(t, 3, chunks)
+
+	chunks = 0
+	require.NoError(t, dk.Walk(10, func([]string) bool {
+		chunks++
+		return false
+	}))
+	assert.Equal(t, 1, chunks)
+}
+
+func TestDiskKeyDir_Reopen(t *testing.T) {
+	tests := []struct {
+		name      string
+		flush     bool
+		cached    bool
+		wantClean bool
+	}{
+		{name: "flushed_is_clean", flush: true, wantClean: true},
+		{name: "unflushed_is_dirty", flush: false, wantClean: false},
+		{name: "flushed_page_cache", flush: true, cached: true, wantClean: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mmap := mmapSupported && !tt.cached
+			dir := t.TempDir()
+			dk, err := openDiskKeyDir(dir, 0, mmap)
+			require.NoError(t, err)
+			for i := 0; i < 2000; i++ {
+				dk.Add(fmt.Sprintf("k%d", i), &DataPosition{Fid: 3, Off: int64(i), Seq: uint64(i + 1)})
+			}
+			if tt.flush {
+				require.NoError(t, dk.Flush(3, 4096, 2000))
+			}
+			require.NoError(t, dk.Close())
+
+			dk, err = openDiskKeyDir(dir, 0, mmap)
+			require.NoError(t, err)
+			defer dk.Close()
+			fid, off, seq, ok := dk.Covered()
+			assert.Equal(t, tt.wantClean, ok)
+			if !tt.wantClean {
+				return
+			}
+			assert.Equal(t, 3, fid)
+			assert.Equal(t, int64(4096), off)
+			assert.Equal(t, uint64(2000), seq)
+			assert.Equal(t, 2000, dk.Len())
+			dp := dk.Find("k1999")
+			require.NotNil(t, dp)
+			assert.Equal(t, int64(1999), dp.Off)
+			assert.Equal(t, uint64(2000), dp.Seq)
+
+			dk.Add("after_open", &DataPosition{})
+			require.NoError(t, dk.Close())
+			dk2, err := openDiskKeyDir(dir, 0, mmap)
+			require.NoError(t, err)
+			defer dk2.Close()
+			_, _, _, ok = dk2.Covered()
+			assert.False(t, ok, "a mutation after open must mark the index dirty")
+		})
+	}
+}
+
+func TestDiskKeyDir_Ascend(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferKeys int
+	}{
+		{name: "in_memory", bufferKeys: 1 << 16},
+		{name: "spilled_runs", bufferKeys: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n int) { sortBufferKeys = n }(sortBufferKeys)
+			sortBufferKeys = tt.bufferKeys
+
+			dir := t.TempDir()
+			dk, err := OpenDiskKeyDir(dir, 0)
+			require.NoError(t, err)
+			defer dk.Close()
+			for i := 999; i >= 0; i-- {
+				dk.Add(fmt.Sprintf("key-%04d", i), &DataPosition{Fid: 1, Off: int64(i)})
+			}
+
+			var keys []string
+			require.NoError(t, dk.Ascend("key-0100", "key-0700", func(key string, dp *DataPosition) bool {
+				assert.Equal(t, key, fmt.Sprintf("key-%04d", dp.Off))
+				// fn runs without the lock, so it may read the keydir.
+				assert.NotNil(t, dk.Find(key))
+				keys = append(keys, key)
+				return true
+			}))
+			require.Len(t, keys, 600)
+			assert.Equal(t, "key-0100", keys[0])
+			assert.Equal(t, "key-0699", keys[599])
+			assert.True(t, sort.StringsAreSorted(keys))
+
+			n := 0
+			require.NoError(t, dk.Ascend("", "", func(string, *DataPosition) bool {
+				n++
+				return n < 5
+			}))
+			assert.Equal(t, 5, n)
+
+			left, err := filepath.Glob(filepath.Join(dir, tempPrefix+"*"))
+			require.NoError(t, err)
+			assert.Empty(t, left, "scratch runs must be removed")
+		})
+	}
+}
+
+func TestOpenDiskKeyDir_RemovesStaleTemp(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, tempPrefix+"123")
+	require.NoError(t, os.Mkdir(stale, 0o755))
+	dk, err := OpenDiskKeyDir(dir, 0)
+	require.NoError(t, err)
+	defer dk.Close()
+	assert.NoDirExists(t, stale)
+}
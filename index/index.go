@@ -1,7 +1,7 @@
 package index
 
 import (
-	"sort"
+	"unsafe"
 
 	"tiny-bitcask/entity"
 )
@@ -10,11 +10,40 @@ const (
 	KeyNotFound = "key not found"
 )
 
+// Index is a keydir: the mapping from key to the position of its live record.
+// Implementations are not safe for concurrent mutation; DB serialises writers.
 type Index interface {
 	Find(key string) *DataPosition
 	Delete(key string)
 	Update(key string, dp *DataPosition)
 	Add(key string, dp *DataPosition)
+	Len() int
+	Bytes() int64
+	Range(fn func(key string, dp *DataPosition) bool)
+	// Ascend calls fn for the keys in [start, end) in lexicographic order
+	// until fn returns false; an empty end leaves the range unbounded.
+	Ascend(start, end string, fn func(key string, dp *DataPosition) bool) error
+	Close() error
+}
+
+// Walker is implemented by an Index that can hand out its keys a chunk at a
+// time rather than all at once. Walk calls fn with successive chunks of at
+// most n keys, in table order, until fn returns false; no lock is held while
+// fn runs, so the caller must keep the index unchanged for the whole walk.
+type Walker interface {
+	Walk(n int, fn func(keys []string) bool) error
+}
+
+// Persistent is implemented by an Index that outlives the process. Covered
+// reports the segment position and sequence high-water mark the index was
+// last flushed at, if it was flushed cleanly; otherwise the caller must Reset
+// it and replay all segments. Err reports a failed mutation, after which the
+// index must be rebuilt.
+type Persistent interface {
+	Covered() (fid int, off int64, seq uint64, ok bool)
+	Flush(fid int, off int64, seq uint64) error
+	Reset() error
+	Err() error
 }
 
 type indexer map[string]*DataPosition
@@ -25,6 +54,8 @@ func newIndexer() indexer {
 
 type KeyDir struct {
 	Index indexer
+	order keyOrder
+	bytes int64
 }
 
 func NewKD() *KeyDir {
@@ -34,6 +65,10 @@ func NewKD() *KeyDir {
 }
 
 func (kd *KeyDir) Add(key string, dp *DataPosition) {
+	if _, exist := kd.Index[key]; !exist {
+		kd.bytes += EntryBytes(key)
+		kd.order.insert(key)
+	}
 	kd.Index[key] = dp
 }
 
@@ -45,14 +80,71 @@ func (kd *KeyDir) Find(key string) *DataPosition {
 
 // Update inserts an index to KeyDir
 func (kd *KeyDir) Update(key string, dp *DataPosition) {
-	kd.Index[key] = dp
+	kd.Add(key, dp)
 }
 
 // Delete deletes an index in KeyDir
 func (kd *KeyDir) Delete(key string) {
+	if _, exist := kd.Index[key]; exist {
+		kd.bytes -= EntryBytes(key)
+		kd.order.remove(key)
+	}
 	delete(kd.Index, key)
 }
 
+// Len returns the number of keys in KeyDir.
+func (kd *KeyDir) Len() int {
+	return len(kd.Index)
+}
+
+// Bytes returns the estimated heap footprint of KeyDir entries.
+func (kd *KeyDir) Bytes() int64 {
+	return kd.bytes
+}
+
+// mapSlotBytes approximates what one map entry costs beyond the key bytes and
+// the DataPosition: string header + pointer in the bucket, the tophash byte and
+// the bucket overflow pointer amortised over 8 slots, scaled up for the ~80%
+// average load factor.
+const mapSlotBytes = (16 + 8 + 1 + 1) * 5 / 4
+
+// EntryBytes estimates the heap bytes KeyDir spends on one key: the key string
+// and the DataPosition rounded to allocator size classes, plus the map slot and
+// the key's place in the sorted order.
+func EntryBytes(key string) int64 {
+	return KeyBytes(key) + PositionBytes() + orderSlotBytes
+}
+
+// KeyBytes estimates what a string-keyed map spends on key besides the value:
+// the key string rounded to an allocator size class, plus the map slot.
+func KeyBytes(key string) int64 {
+	return allocBytes(len(key)) + mapSlotBytes
+}
+
+// PositionBytes is the heap size of one DataPosition, rounded to its allocator
+// size class.
+func PositionBytes() int64 {
+	return allocBytes(int(unsafe.Sizeof(DataPosition{})))
+}
+
+// allocBytes rounds n up to the Go allocator's small size classes (8-byte steps
+// to 32, 16-byte steps to 256, 32-byte steps to 512, then 64-byte steps), close
+// enough for accounting.
+func allocBytes(n int) int64 {
+	switch {
+	case n == 0:
+		return 0
+	case n <= 32:
+		return int64((n + 7) &^ 7)
+	case n <= 256:
+		return int64((n + 15) &^ 15)
+	case n <= 512:
+		return int64((n + 31) &^ 31)
+	default:
+		return int64((n + 63) &^ 63)
+	}
+}
+
 // DataPosition means a certain position of an entity.Entry which stores in disk.
 type DataPosition struct {
 	Fid       int
@@ -60,15 +152,22 @@ type DataPosition struct {
 	Timestamp uint64
 	KeySize   int
 	ValueSize int
+	Expiry    uint64 // unix nanoseconds after which the key is gone; 0 = never
+	Seq       uint64 // the record's global sequence number
 }
 
-func (kd *KeyDir) AddIndexByData(hint *entity.Hint, entry *entity.Entry) {
-	kd.AddIndexByRawInfo(hint.Fid, hint.Off, entry.Key, entry.Value, entry.Meta.TimeStamp)
+// Expired reports whether the record's TTL has passed at now (unix nanoseconds).
+func (i *DataPosition) Expired(now int64) bool {
+	return i.Expiry != 0 && i.Expiry <= uint64(now)
 }
 
-func (kd *KeyDir) AddIndexByRawInfo(fid int, off int64, key, value []byte, ts uint64) {
-	index := newDataPosition(fid, off, key, value, ts)
-	kd.Add(string(key), index)
+// Close is a no-op; the in-memory keydir holds no external resources.
+func (kd *KeyDir) Close() error {
+	return nil
+}
+
+func AddIndexByData(idx Index, hint *entity.Hint, entry *entity.Entry) {
+	AddIndexBySizes(idx, hint.Fid, hint.Off, entry.Key, int(entry.Meta.KeySize), int(entry.Meta.ValueSize), entry.Meta.TimeStamp, entry.Meta.Expiry, entry.Meta.Seq)
 }
 
 // Range visits every key in arbitrary map order until fn returns false.
@@ -80,36 +179,39 @@ func (kd *KeyDir) Range(fn func(key string, dp *DataPosition) bool) {
 	}
 }
 
-// SortedKeys returns keys in lexicographic order (copy).
-func (kd *KeyDir) SortedKeys() []string {
-	keys := make([]string, 0, len(kd.Index))
-	for k := range kd.Index {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	return keys
+// Ascend implements Index by walking the keys in their kept order, so a seek
+// costs O(log n) and the walk O(keys visited). fn must not modify kd.
+func (kd *KeyDir) Ascend(start, end string, fn func(key string, dp *DataPosition) bool) error {
+	kd.order.ascend(start, end, func(key string) bool {
+		return fn(key, kd.Index[key])
+	})
+	return nil
 }
 
 // AddIndexBySizes records keydir metadata without reading the value (e.g. hint recovery).
-func (kd *KeyDir) AddIndexBySizes(fid int, off int64, key []byte, keySize, valueSize int, ts uint64) {
+func AddIndexBySizes(idx Index, fid int, off int64, key []byte, keySize, valueSize int, ts, expiry, seq uint64) {
 	dp := &DataPosition{
 		Fid:       fid,
 		Off:       off,
 		Timestamp: ts,
 		KeySize:   keySize,
 		ValueSize: valueSize,
+		Expiry:    expiry,
+		Seq:       seq,
 	}
-	kd.Add(string(key), dp)
-}
-
-func newDataPosition(fid int, off int64, key, value []byte, ts uint64) *DataPosition {
-	dp := &DataPosition{}
-	dp.Fid = fid
-	dp.Off = off
-	dp.Timestamp = ts
-	dp.KeySize = len(key)
-	dp.ValueSize = len(value)
-	return dp
+	idx.Add(string(key), dp)
+}
+
+// RangeKeys returns the keys of idx in [start, end) in order, an empty end
+// leaving the range unbounded. It seeks to start with Ascend, so over the
+// in-memory keydir the cost follows the keys returned, not the keys held.
+func RangeKeys(idx Index, start, end []byte) ([]string, error) {
+	var keys []string
+	err := idx.Ascend(string(start), string(end), func(key string, _ *DataPosition) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, err
 }
 
 func (i *DataPosition) IsEqualPos(fid int, off int64) bool {
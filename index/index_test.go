@@ -0,0 +1,59 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyDir_Bytes(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(kd *KeyDir)
+		want func() int64
+	}{
+		{
+			name: "empty",
+			run:  func(*KeyDir) {},
+			want: func() int64 { return 0 },
+		},
+		{
+			name: "add_two_keys",
+			run: func(kd *KeyDir) {
+				kd.Add("a", &DataPosition{})
+				kd.Add("bbbbbbbbbbbbbbbbbbbb", &DataPosition{})
+			},
+			want: func() int64 { return EntryBytes("a") + EntryBytes("bbbbbbbbbbbbbbbbbbbb") },
+		},
+		{
+			name: "overwrite_does_not_grow",
+			run: func(kd *KeyDir) {
+				kd.Add("a", &DataPosition{Fid: 1})
+				kd.Update("a", &DataPosition{Fid: 2})
+			},
+			want: func() int64 { return EntryBytes("a") },
+		},
+		{
+			name: "delete_releases",
+			run: func(kd *KeyDir) {
+				kd.Add("a", &DataPosition{})
+				kd.Add("b", &DataPosition{})
+				kd.Delete("a")
+				kd.Delete("missing")
+			},
+			want: func() int64 { return EntryBytes("b") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kd := NewKD()
+			tt.run(kd)
+			assert.Equal(t, tt.want(), kd.Bytes())
+		})
+	}
+}
+
+func TestEntryBytes_GrowsWithKey(t *testing.T) {
+	assert.Less(t, EntryBytes("k"), EntryBytes(string(make([]byte, 100))))
+	assert.Greater(t, EntryBytes(""), int64(0))
+}
@@ -0,0 +1,89 @@
+package index
+
+import (
+	"slices"
+	"sort"
+)
+
+// orderBlockKeys is the most keys one keyOrder block holds before it splits.
+const orderBlockKeys = 512
+
+// orderSlotBytes approximates what keyOrder spends per key: a string header in
+// a block, scaled up for blocks being three-quarters full on average.
+const orderSlotBytes = 16 * 4 / 3
+
+// keyOrder keeps KeyDir's keys sorted in blocks of at most orderBlockKeys, so
+// a seek is two binary searches and an insert or delete moves at most one
+// block's keys (plus, on a split or merge, the block headers).
+type keyOrder struct {
+	blocks [][]string
+}
+
+// locate returns the block that holds key or would receive it, and key's
+// position in that block. b is len(o.blocks) only when there are no blocks.
+func (o *keyOrder) locate(key string) (b, i int) {
+	b = sort.Search(len(o.blocks), func(j int) bool {
+		blk := o.blocks[j]
+		return blk[len(blk)-1] >= key
+	})
+	if b == len(o.blocks) && b > 0 {
+		b--
+	}
+	if b == len(o.blocks) {
+		return b, 0
+	}
+	return b, sort.SearchStrings(o.blocks[b], key)
+}
+
+// insert adds key, which must not be present.
+func (o *keyOrder) insert(key string) {
+	b, i := o.locate(key)
+	if b == len(o.blocks) {
+		o.blocks = append(o.blocks, []string{key})
+		return
+	}
+	blk := slices.Insert(o.blocks[b], i, key)
+	if len(blk) <= orderBlockKeys {
+		o.blocks[b] = blk
+		return
+	}
+	half := len(blk) / 2
+	right := append(make([]string, 0, orderBlockKeys), blk[half:]...)
+	clear(blk[half:])
+	o.blocks[b] = blk[:half]
+	o.blocks = slices.Insert(o.blocks, b+1, right)
+}
+
+// remove drops key if present, folding a block that has shrunk to a quarter
+// into its right neighbour when they fit in one.
+func (o *keyOrder) remove(key string) {
+	b, i := o.locate(key)
+	if b == len(o.blocks) || i == len(o.blocks[b]) || o.blocks[b][i] != key {
+		return
+	}
+	blk := slices.Delete(o.blocks[b], i, i+1)
+	o.blocks[b] = blk
+	switch {
+	case len(blk) == 0:
+		o.blocks = slices.Delete(o.blocks, b, b+1)
+	case len(blk) < orderBlockKeys/4 && b+1 < len(o.blocks) && len(blk)+len(o.blocks[b+1]) <= orderBlockKeys:
+		o.blocks[b] = append(blk, o.blocks[b+1]...)
+		o.blocks = slices.Delete(o.blocks, b+1, b+2)
+	}
+}
+
+// ascend calls fn for each key in [start, end) in order until fn returns
+// false; an empty end leaves the range unbounded.
+func (o *keyOrder) ascend(start, end string, fn func(key string) bool) {
+	b, i := o.locate(start)
+	for ; b < len(o.blocks); b, i = b+1, 0 {
+		for _, k := range o.blocks[b][i:] {
+			if end != "" && k >= end {
+				return
+			}
+			if !fn(k) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package index
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// runMemoryKeys is how many entries a Run keeps in memory before it spills to
+// its directory. A variable so tests can force spills.
+var runMemoryKeys = 1 << 16
+
+// runStride is how many spilled entries share one in-memory index mark, and
+// so how many entries a cache miss reads.
+const runStride = 128
+
+var ErrRunClosed = errors.New("index: run closed")
+
+// Run is a frozen, ordered copy of the keys and positions an Index held in a
+// range, for readers that walk or seek it while the Index moves on. A run
+// built with a directory spills to a scratch file there once it passes
+// runMemoryKeys entries and then keeps only every runStride-th key in memory;
+// without one it stays in memory. The zero Run is empty. Safe for concurrent
+// use.
+type Run struct {
+	mu  sync.Mutex
+	n   int
+	mem []sortEntry
+
+	f      *os.File
+	marks  []runMark // first key and file offset of each runStride entries
+	block  int       // which marks entry cached holds, -1 for none
+	cached []sortEntry
+
+	closed bool
+}
+
+type runMark struct {
+	key string
+	off int64
+}
+
+// NewRun copies the entries of idx in [start, end) that keep accepts (all of
+// them when keep is nil) into a Run, spilling to dir when it is not empty. An
+// empty end leaves the range unbounded. The caller keeps idx unchanged for the
+// duration and closes the Run when done.
+func NewRun(idx Index, start, end, dir string, keep func(key string, dp *DataPosition) bool) (*Run, error) {
+	r := &Run{block: -1}
+	var (
+		w    *bufio.Writer
+		off  int64
+		werr error
+	)
+	add := func(e *sortEntry) error {
+		if r.n%runStride == 0 {
+			r.marks = append(r.marks, runMark{key: e.key, off: off})
+		}
+		n, err := writeEntry(w, e)
+		off += int64(n)
+		r.n++
+		return err
+	}
+	err := idx.Ascend(start, end, func(key string, dp *DataPosition) bool {
+		if keep != nil && !keep(key, dp) {
+			return true
+		}
+		e := sortEntry{key: key, dp: *dp}
+		if w != nil {
+			werr = add(&e)
+			return werr == nil
+		}
+		r.mem = append(r.mem, e)
+		if dir == "" || len(r.mem) <= runMemoryKeys {
+			return true
+		}
+		if r.f, werr = os.CreateTemp(dir, tempPrefix); werr != nil {
+			return false
+		}
+		w = bufio.NewWriter(r.f)
+		mem := r.mem
+		r.mem, r.n = nil, 0
+		for i := range mem {
+			if werr = add(&mem[i]); werr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = werr
+	}
+	if err == nil && w != nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if r.f == nil {
+		r.n = len(r.mem)
+	}
+	return r, nil
+}
+
+// Len returns the number of entries.
+func (r *Run) Len() int {
+	return r.n
+}
+
+// At returns entry i, 0 <= i < Len.
+func (r *Run) At(i int) (string, *DataPosition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return "", nil, ErrRunClosed
+	}
+	es := r.mem
+	if r.f != nil {
+		if err := r.load(i / runStride); err != nil {
+			return "", nil, err
+		}
+		es, i = r.cached, i%runStride
+	}
+	dp := es[i].dp
+	return es[i].key, &dp, nil
+}
+
+// Search returns the index of the first entry whose key is >= key, or Len
+// when there is none.
+func (r *Run) Search(key string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, ErrRunClosed
+	}
+	if r.f == nil {
+		return sort.Search(len(r.mem), func(i int) bool { return r.mem[i].key >= key }), nil
+	}
+	b := sort.Search(len(r.marks), func(i int) bool { return r.marks[i].key > key }) - 1
+	if b < 0 {
+		return 0, nil
+	}
+	if err := r.load(b); err != nil {
+		return 0, err
+	}
+	return b*runStride + sort.Search(len(r.cached), func(i int) bool { return r.cached[i].key >= key }), nil
+}
+
+// load reads the b-th runStride entries of the spill file into cached.
+func (r *Run) load(b int) error {
+	if r.block == b {
+		return nil
+	}
+	n := min(runStride, r.n-b*runStride)
+	br := bufio.NewReader(io.NewSectionReader(r.f, r.marks[b].off, 1<<62))
+	cached := make([]sortEntry, n)
+	for i := range cached {
+		e, err := readEntry(br)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		cached[i] = e
+	}
+	r.block, r.cached = b, cached
+	return nil
+}
+
+// Close drops the entries and removes the spill file, if any.
+func (r *Run) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.mem, r.marks, r.cached = nil, nil, nil
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	if rerr := os.Remove(r.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package index
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		dir       bool
+		wantSpill bool
+	}{
+		{name: "in_memory"},
+		{name: "spilled", dir: true, wantSpill: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n int) { runMemoryKeys = n }(runMemoryKeys)
+			runMemoryKeys = 50
+
+			kd := NewKD()
+			for i := 0; i < 1000; i++ {
+				kd.Add(fmt.Sprintf("k%04d", i), &DataPosition{Off: int64(i)})
+			}
+			dir := ""
+			if tt.dir {
+				dir = t.TempDir()
+			}
+			r, err := NewRun(kd, "k0100", "k0900", dir, func(_ string, dp *DataPosition) bool {
+				return dp.Off%2 == 0
+			})
+			require.NoError(t, err)
+			assert.Equal(t, 400, r.Len())
+			kd.Delete("k0100") // the run is a copy
+
+			key, dp, err := r.At(0)
+			require.NoError(t, err)
+			assert.Equal(t, "k0100", key)
+			assert.Equal(t, int64(100), dp.Off)
+			key, _, err = r.At(399)
+			require.NoError(t, err)
+			assert.Equal(t, "k0898", key)
+
+			for _, c := range []struct {
+				key  string
+				want int
+			}{
+				{"", 0}, {"k0100", 0}, {"k0101", 1}, {"k0356", 128}, {"k0898", 399}, {"k0899", 400}, {"z", 400},
+			} {
+				i, err := r.Search(c.key)
+				require.NoError(t, err)
+				assert.Equal(t, c.want, i, c.key)
+			}
+
+			if tt.dir {
+				files, _ := filepath.Glob(filepath.Join(dir, tempPrefix+"*"))
+				assert.Equal(t, tt.wantSpill, len(files) == 1)
+			}
+			require.NoError(t, r.Close())
+			_, _, err = r.At(0)
+			assert.ErrorIs(t, err, ErrRunClosed)
+			if tt.dir {
+				files, _ := filepath.Glob(filepath.Join(dir, tempPrefix+"*"))
+				assert.Empty(t, files)
+			}
+		})
+	}
+}
+
+func TestKeyDir_Ascend(t *testing.T) {
+	kd := NewKD()
+	// Enough keys, added out of order, to split and merge order blocks.
+	for i := 0; i < 5000; i++ {
+		kd.Add(fmt.Sprintf("k%04d", (i*7919)%5000), &DataPosition{Off: int64(i)})
+	}
+	for i := 0; i < 5000; i += 3 {
+		kd.Delete(fmt.Sprintf("k%04d", i))
+	}
+	var keys []string
+	require.NoError(t, kd.Ascend("", "", func(key string, dp *DataPosition) bool {
+		require.NotNil(t, dp)
+		keys = append(keys, key)
+		return true
+	}))
+	require.Len(t, keys, kd.Len())
+	for i := 1; i < len(keys); i++ {
+		require.Less(t, keys[i-1], keys[i])
+	}
+
+	keys = nil
+	require.NoError(t, kd.Ascend("k1000", "k1010", func(key string, _ *DataPosition) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"k1000", "k1001", "k1003", "k1004", "k1006", "k1007", "k1009"}, keys)
+}
@@ -0,0 +1,200 @@
+package tiny_bitcask
+
+import (
+	"iter"
+	"time"
+
+	"tiny-bitcask/index"
+)
+
+// KV is one key/value pair yielded by Entries.
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// Keys yields every key in lexicographic order, leaving out the reserved keys
+// of collections and data structures. Keys are read a chunk at a time as the
+// loop advances and no lock is held while the loop body runs; see rangeKeys.
+func (db *DB) Keys() iter.Seq[[]byte] {
+	return seqKeys(db.rangeKeys(nil, nil, false))
+}
+
+// All yields every key and its current value in lexicographic key order; see Scan.
+func (db *DB) All() iter.Seq2[[]byte, []byte] {
+	return db.Scan(nil, nil)
+}
+
+// Scan yields keys in [start, end) in lexicographic order with their values; a
+// nil bound is open. Reserved keys are left out, as by Keys. Each value is
+// read lazily under a short read lock, so writers are never blocked by the
+// loop body and keys deleted meanwhile are skipped. Iteration stops at the
+// first read error; use Entries to observe it.
+func (db *DB) Scan(start, end []byte) iter.Seq2[[]byte, []byte] {
+	return seqValues(db.Entries(start, end))
+}
+
+// Entries is the error-reporting form of Scan: a failed read yields the error
+// (with KV.Key set) as the final element.
+func (db *DB) Entries(start, end []byte) iter.Seq2[KV, error] {
+	return seqEntries(db.rangeKeys(start, end, false), db.Get)
+}
+
+// entries is Entries including reserved keys, for the owners of a reserved
+// prefix.
+func (db *DB) entries(start, end []byte) iter.Seq2[KV, error] {
+	return seqEntries(db.rangeKeys(start, end, true), db.Get)
+}
+
+// seqKeys yields keys, stopping at the first error.
+func seqKeys(keys iter.Seq2[string, error]) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for k, err := range keys {
+			if err != nil || !yield([]byte(k)) {
+				return
+			}
+		}
+	}
+}
+
+// seqEntries reads each key with get, skipping keys that are gone.
+func seqEntries(keys iter.Seq2[string, error], get func([]byte) ([]byte, error)) iter.Seq2[KV, error] {
+	return func(yield func(KV, error) bool) {
+		for k, err := range keys {
+			if err != nil {
+				yield(KV{Key: []byte(k)}, err)
+				return
+			}
+			value, err := get([]byte(k))
+			if err == KeyNotFoundErr {
+				continue
+			}
+			if err != nil {
+				yield(KV{Key: []byte(k)}, err)
+				return
+			}
+			if !yield(KV{Key: []byte(k), Value: value}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// seqValues drops the error column, stopping at the first error.
+func seqValues(entries iter.Seq2[KV, error]) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for kv, err := range entries {
+			if err != nil || !yield(kv.Key, kv.Value) {
+				return
+			}
+		}
+	}
+}
+
+// iterChunkKeys is how many keys rangeKeys reads per read lock from the
+// in-memory keydir.
+const iterChunkKeys = 256
+
+// rangeKeys yields the live keys in [start, end) in order, and reserved keys
+// only when reserved is set; a nil bound is open. No lock is held while the
+// loop body runs. The in-memory keydir is read iterChunkKeys keys at a time
+// under a short read lock, each chunk resuming after the last key yielded, so
+// nothing is copied ahead of the loop: a key written beyond the loop's position
+// is seen and one deleted before it is reached is not. An on-disk keydir keeps
+// no order, so the range is frozen into a Run when iteration starts. An error
+// is yielded as the final element.
+func (db *DB) rangeKeys(start, end []byte, reserved bool) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if end != nil && string(end) <= string(start) {
+			return
+		}
+		if db.opt.KeydirOnDisk {
+			run, err := db.newRun(start, end, reserved)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			defer run.Close()
+			seqRun(0, run.Len(), run.At)(yield)
+			return
+		}
+		ranges := []keyRange{{start, end}}
+		if !reserved {
+			ranges = unreservedRanges(start, end)
+		}
+		for _, r := range ranges {
+			if !db.ascendChunks(string(r.start), string(r.end), yield) {
+				return
+			}
+		}
+	}
+}
+
+// ascendChunks yields the live keys of the in-memory keydir in [start, end) a
+// chunk at a time, reporting false if yield stopped the loop.
+func (db *DB) ascendChunks(start, end string, yield func(string, error) bool) bool {
+	keys := make([]string, 0, iterChunkKeys)
+	for {
+		keys = keys[:0]
+		db.rw.RLock()
+		now := time.Now().UnixNano()
+		err := db.kd.Ascend(start, end, func(key string, dp *index.DataPosition) bool {
+			if !dp.Expired(now) {
+				keys = append(keys, key)
+			}
+			return len(keys) < iterChunkKeys
+		})
+		db.rw.RUnlock()
+		if err != nil {
+			yield("", err)
+			return false
+		}
+		for _, k := range keys {
+			if !yield(k, nil) {
+				return false
+			}
+		}
+		if len(keys) < iterChunkKeys {
+			return true
+		}
+		start = keys[len(keys)-1] + "\x00"
+	}
+}
+
+// seqRun yields the keys of a run at positions [lo, hi) as read by at,
+// stopping after the first error.
+func seqRun(lo, hi int, at func(i int) (string, *index.DataPosition, error)) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for i := lo; i < hi; i++ {
+			key, _, err := at(i)
+			if !yield(key, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// newRun freezes the live keys in [start, end) under the read lock, and
+// reserved keys only when reserved is set; a nil bound is open. Over an
+// on-disk keydir a large run spills to a scratch file in Options.Dir, so no
+// path holds every key in memory. The caller closes it.
+func (db *DB) newRun(start, end []byte, reserved bool) (*index.Run, error) {
+	if end != nil && string(end) <= string(start) {
+		return &index.Run{}, nil
+	}
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	now := time.Now().UnixNano()
+	return index.NewRun(db.kd, string(start), string(end), db.spillDir(), func(key string, dp *index.DataPosition) bool {
+		return !dp.Expired(now) && (reserved || checkKey([]byte(key)) == nil)
+	})
+}
+
+// spillDir is where a Run may spill: Options.Dir over an on-disk keydir, and
+// nowhere over the in-memory one, whose keys are in memory anyway.
+func (db *DB) spillDir() string {
+	if db.opt.KeydirOnDisk {
+		return db.opt.Dir
+	}
+	return ""
+}
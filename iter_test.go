@@ -0,0 +1,173 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/storage"
+)
+
+func TestDB_Iterators(t *testing.T) {
+	tests := []struct {
+		name  string
+		start []byte
+		end   []byte
+		want  []string
+	}{
+		{name: "all", want: []string{"a=1", "b=2", "c=3", "d=4"}},
+		{name: "half_open_range", start: []byte("b"), end: []byte("d"), want: []string{"b=2", "c=3"}},
+		{name: "open_end", start: []byte("bb"), want: []string{"c=3", "d=4"}},
+		{name: "open_start", end: []byte("b"), want: []string{"a=1"}},
+		{name: "empty_range", start: []byte("x"), end: []byte("a"), want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, nil)
+			defer db.Close()
+			for _, kv := range [][2]string{{"c", "3"}, {"a", "1"}, {"d", "4"}, {"b", "2"}} {
+				require.NoError(t, db.Set([]byte(kv[0]), []byte(kv[1])))
+			}
+
+			var got []string
+			for k, v := range db.Scan(tt.start, tt.end) {
+				got = append(got, string(k)+"="+string(v))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDB_Iterators_KeysAllBreakAndWrite(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, db.Set([]byte(k), []byte(k)))
+	}
+
+	var keys []string
+	for k := range db.Keys() {
+		keys = append(keys, string(k))
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+	// Writing from the loop body must not deadlock; deleted keys are skipped.
+	var seen []string
+	for k, v := range db.All() {
+		seen = append(seen, string(k))
+		assert.Equal(t, string(k), string(v))
+		if string(k) == "a" {
+			require.NoError(t, db.Delete([]byte("b")))
+		}
+		if string(k) == "c" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a", "c"}, seen)
+}
+
+func TestDB_Entries_ReportsReadError(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "iterr")
+	opt := *DefaultOptions
+	opt.Dir = dataDir
+	db, err := NewDB(&opt)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("k"), []byte("v")))
+
+	dat := filepath.Join(dataDir, "1.dat")
+	b, err := os.ReadFile(dat)
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xFF
+	require.NoError(t, os.WriteFile(dat, b, 0o644))
+
+	var errs []error
+	for kv, err := range db.Entries(nil, nil) {
+		assert.Equal(t, "k", string(kv.Key))
+		errs = append(errs, err)
+	}
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], storage.CrcErr)
+
+	n := 0
+	for range db.All() {
+		n++
+	}
+	assert.Zero(t, n, "All stops at the failed read")
+}
+
+func TestDB_Iterators_SkipReservedKeys(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	for _, k := range []string{"a", "z"} {
+		require.NoError(t, db.Set([]byte(k), []byte(k)))
+	}
+	require.NoError(t, db.HSet([]byte("h"), []byte("f"), []byte("v")))
+	users, err := NewCollection(db, "users", StringCodec{}, StringCodec{})
+	require.NoError(t, err)
+	require.NoError(t, users.Put("ann", "x"))
+
+	keysOf := func(seq func(func([]byte) bool)) []string {
+		var keys []string
+		for k := range seq {
+			keys = append(keys, string(k))
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"a", "z"}, keysOf(db.Keys()))
+	n := 0
+	for range db.Scan([]byte("\x00"), []byte("b")) {
+		n++
+	}
+	assert.Equal(t, 1, n, "a range spanning the reserved prefixes yields only plain keys")
+
+	c := db.NewCursor(nil)
+	var cursorKeys []string
+	for ok := c.First(); ok; ok = c.Next() {
+		cursorKeys = append(cursorKeys, string(c.Key()))
+	}
+	c.Close()
+	assert.Equal(t, []string{"a", "z"}, cursorKeys)
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+	assert.Equal(t, []string{"a", "z"}, keysOf(snap.Keys()))
+
+	// The owners still see their keys.
+	all := 0
+	for range users.All() {
+		all++
+	}
+	assert.Equal(t, 1, all)
+	fields, err := db.HGetAll([]byte("h"))
+	require.NoError(t, err)
+	assert.Len(t, fields, 1)
+}
+
+func TestDB_Iterators_ReadAsTheLoopAdvances(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	for i := 0; i < 3*iterChunkKeys; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k%04d", i)), []byte("v")))
+	}
+
+	// Keys are not copied ahead of the loop, so one written beyond its
+	// position is seen and one deleted before it is reached is not.
+	n := 0
+	seen := map[string]bool{}
+	for k := range db.Keys() {
+		if n == 0 {
+			require.NoError(t, db.Set([]byte("k9999"), []byte("v")))
+			require.NoError(t, db.Delete([]byte("k0500")))
+		}
+		seen[string(k)] = true
+		n++
+	}
+	assert.Equal(t, 3*iterChunkKeys, n)
+	assert.True(t, seen["k9999"])
+	assert.False(t, seen["k0500"])
+}
@@ -0,0 +1,116 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyStore copies testdata/legacy-store, written by the original release
+// (segments without a header, 29-byte record meta, version 1 hints), to a
+// fresh directory and returns it with the contents it should hold.
+func legacyStore(t *testing.T) (string, map[string]string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	files, err := os.ReadDir(filepath.Join("testdata", "legacy-store"))
+	require.NoError(t, err)
+	for _, f := range files {
+		b, err := os.ReadFile(filepath.Join("testdata", "legacy-store", f.Name()))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f.Name()), b, 0o644))
+	}
+
+	want := map[string]string{"last": "in the active segment"}
+	for i := 0; i < 30; i++ {
+		v := fmt.Sprintf("value-%02d", i)
+		if i%3 == 0 {
+			v = fmt.Sprintf("updated-%02d", i)
+		}
+		if i%5 != 1 {
+			want[fmt.Sprintf("key-%02d", i)] = v
+		}
+	}
+	return dir, want
+}
+
+func assertContents(t *testing.T, db *DB, want map[string]string) {
+	t.Helper()
+	got := map[string]string{}
+	require.NoError(t, db.Fold(func(key, value []byte) error {
+		got[string(key)] = string(value)
+		return nil
+	}))
+	assert.Equal(t, want, got)
+}
+
+func TestLegacyStore_Open(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  func(*Options)
+	}{
+		{name: "read_write", opt: func(o *Options) {}},
+		{name: "read_only", opt: func(o *Options) { o.ReadOnly = true }},
+		{name: "keydir_on_disk", opt: func(o *Options) { o.KeydirOnDisk = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, want := legacyStore(t)
+			opt := *DefaultOptions
+			opt.Dir = dir
+			tt.opt(&opt)
+			db, err := NewDB(&opt)
+			require.NoError(t, err)
+			defer db.Close()
+
+			assertContents(t, db, want)
+			_, err = db.Get([]byte("key-01"))
+			assert.Equal(t, KeyNotFoundErr, err)
+			ttl, err := db.TTL([]byte("key-02"))
+			require.NoError(t, err)
+			assert.Zero(t, ttl)
+			_, ver, err := db.GetWithVersion([]byte("key-02"))
+			require.NoError(t, err)
+			assert.NotEqual(t, NoVersion, ver)
+		})
+	}
+}
+
+// TestLegacyStore_Upgrade writes to a legacy store, merges it into the current
+// format and reopens it.
+func TestLegacyStore_Upgrade(t *testing.T) {
+	dir, want := legacyStore(t)
+	opt := *DefaultOptions
+	opt.Dir = dir
+	opt.SegmentSize = 512
+	db, err := NewDB(&opt)
+	require.NoError(t, err)
+
+	_, before, err := db.GetWithVersion([]byte("key-02"))
+	require.NoError(t, err)
+	_, err = db.SetIfAbsent([]byte("key-02"), []byte("x"))
+	assert.Equal(t, VersionMismatchErr, err, "a legacy key must not look absent")
+	require.NoError(t, db.Set([]byte("new"), []byte("after upgrade")))
+	require.NoError(t, db.Delete([]byte("key-04")))
+	want["new"] = "after upgrade"
+	delete(want, "key-04")
+	newVer, err := db.SetIfVersion([]byte("key-05"), []byte("v5"), versionOf(db.kd.Find("key-05")))
+	require.NoError(t, err)
+	assert.Greater(t, uint64(newVer), uint64(before))
+	want["key-05"] = "v5"
+
+	require.NoError(t, db.Merge())
+	assertContents(t, db, want)
+	require.NoError(t, db.Close())
+
+	db, err = NewDB(&opt)
+	require.NoError(t, err)
+	defer db.Close()
+	assertContents(t, db, want)
+	_, err = db.SetIfVersion([]byte("key-02"), []byte("v2"), before)
+	assert.NoError(t, err, "merge must keep a legacy record's version")
+}
@@ -0,0 +1,100 @@
+package tiny_bitcask
+
+import (
+	"sort"
+
+	"tiny-bitcask/index"
+)
+
+const (
+	// multiGetGapBytes is the largest run of unwanted bytes MultiGet will read
+	// through to join two records into one ReadAt.
+	multiGetGapBytes = 4 * 1024
+	// multiGetSpanBytes caps a single joined read.
+	multiGetSpanBytes = 1024 * 1024
+)
+
+// multiGetRead is one key of a MultiGet call resolved to its record.
+type multiGetRead struct {
+	i  int
+	dp *index.DataPosition
+}
+
+// MultiGet looks up keys under a single read lock. Reads are sorted by
+// (segment, offset) and records close together in the same segment are fetched
+// with one ReadAt. values[i] and errs[i] answer keys[i]; a missing key gets
+// KeyNotFoundErr.
+func (db *DB) MultiGet(keys [][]byte) (values [][]byte, errs []error) {
+	values = make([][]byte, len(keys))
+	errs = make([]error, len(keys))
+	db.counters.gets.Add(uint64(len(keys)))
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+
+	reads := make([]multiGetRead, 0, len(keys))
+	for i, k := range keys {
+		dp := db.find(string(k))
+		if dp == nil {
+			errs[i] = KeyNotFoundErr
+			continue
+		}
+		reads = append(reads, multiGetRead{i: i, dp: dp})
+	}
+	sort.Slice(reads, func(a, b int) bool {
+		pa, pb := reads[a].dp, reads[b].dp
+		if pa.Fid != pb.Fid {
+			return pa.Fid < pb.Fid
+		}
+		return pa.Off < pb.Off
+	})
+
+	for len(reads) > 0 {
+		n, start, end := 1, reads[0].dp.Off, db.recordEnd(reads[0].dp)
+		for ; n < len(reads); n++ {
+			dp := reads[n].dp
+			if dp.Fid != reads[0].dp.Fid || dp.Off > end+multiGetGapBytes {
+				break
+			}
+			e := db.recordEnd(dp)
+			if e < end {
+				e = end
+			}
+			if e-start > multiGetSpanBytes {
+				break
+			}
+			end = e
+		}
+		db.readSpan(reads[:n], start, end, values, errs)
+		reads = reads[n:]
+	}
+	return values, errs
+}
+
+// readSpan fetches [start, end) of one segment and decodes each read from it.
+// Values are copied out when several share the buffer so none pins the rest.
+func (db *DB) readSpan(reads []multiGetRead, start, end int64, values [][]byte, errs []error) {
+	buf, err := db.storage.ReadRange(reads[0].dp.Fid, start, int(end-start))
+	if err != nil {
+		for _, r := range reads {
+			errs[r.i] = err
+		}
+		return
+	}
+	for _, r := range reads {
+		rec := buf[r.dp.Off-start : db.recordEnd(r.dp)-start]
+		entry, err := db.storage.DecodeEntry(r.dp.Fid, r.dp.Off, rec)
+		if err != nil {
+			errs[r.i] = err
+			continue
+		}
+		if len(reads) > 1 {
+			values[r.i] = cloneBytes(entry.Value)
+		} else {
+			values[r.i] = entry.Value
+		}
+	}
+}
+
+func (db *DB) recordEnd(dp *index.DataPosition) int64 {
+	return dp.Off + db.storage.RecordLen(dp.Fid, dp.KeySize, dp.ValueSize)
+}
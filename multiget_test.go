@@ -0,0 +1,65 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/storage"
+)
+
+func TestMultiGet(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	defer db.Close()
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k%03d", i)), []byte(fmt.Sprintf("v%03d-%040d", i, i))))
+	}
+	// Overwrites and deletes leave gaps between live records.
+	for i := 0; i < 200; i += 7 {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k%03d", i)), []byte(fmt.Sprintf("new%03d", i))))
+	}
+	require.NoError(t, db.Delete([]byte("k005")))
+
+	var keys [][]byte
+	for i := 199; i >= 0; i -= 3 {
+		keys = append(keys, []byte(fmt.Sprintf("k%03d", i)))
+	}
+	keys = append(keys, []byte("k005"), []byte("nope"), []byte("k199"))
+
+	values, errs := db.MultiGet(keys)
+	require.Len(t, values, len(keys))
+	require.Len(t, errs, len(keys))
+	for i, k := range keys {
+		want, wantErr := db.Get(k)
+		if wantErr != nil {
+			assert.ErrorIs(t, errs[i], wantErr, string(k))
+			assert.Nil(t, values[i])
+			continue
+		}
+		require.NoError(t, errs[i], string(k))
+		assert.Equal(t, string(want), string(values[i]), string(k))
+	}
+}
+
+func TestMultiGet_CRCErrorIsPerKey(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("a"), []byte("aaaa")))
+	require.NoError(t, db.Set([]byte("b"), []byte("bbbb")))
+
+	// b is the last record; flip its final value byte in place.
+	path := storage.DataFilePath(db.opt.Dir, 1)
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw[len(raw)-1] ^= 0xFF
+	require.NoError(t, os.WriteFile(path, raw, 0o644))
+
+	values, errs := db.MultiGet([][]byte{[]byte("b"), []byte("a")})
+	assert.ErrorIs(t, errs[0], storage.CrcErr)
+	require.NoError(t, errs[1])
+	assert.Equal(t, "aaaa", string(values[1]))
+}
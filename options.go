@@ -1,6 +1,10 @@
 package tiny_bitcask
 
-import "tiny-bitcask/storage"
+import (
+	"time"
+
+	"tiny-bitcask/storage"
+)
 
 const (
 	DefaultSegmentSize = 256 * storage.MB
@@ -8,18 +12,26 @@ const (
 
 var (
 	DefaultOptions = &Options{
-		Dir:             "db",
-		SegmentSize:     DefaultSegmentSize,
-		VerifyCRC:       true,
-		ExclusiveLock:   true,
+		Dir:           "db",
+		SegmentSize:   DefaultSegmentSize,
+		VerifyCRC:     true,
+		ExclusiveLock: true,
 	}
 )
 
 // Options configures the database. Zero value is not valid; use DefaultOptions or set fields explicitly.
 type Options struct {
-	Dir           string
-	SegmentSize   int64
-	VerifyCRC     bool // verify CRC32 on every read (default true when using DefaultOptions)
-	ReadOnly      bool // open existing store read-only (ListKeys, Get, Fold allowed)
-	ExclusiveLock bool // advisory flock on .tiny-bitcask.lock (Unix); shared lock when ReadOnly
+	Dir                 string
+	SegmentSize         int64
+	VerifyCRC           bool                 // verify CRC32 on every read (default true when using DefaultOptions)
+	ReadOnly            bool                 // open existing store read-only (ListKeys, Get, Fold allowed)
+	ExclusiveLock       bool                 // advisory flock on .tiny-bitcask.lock (Unix); shared lock when ReadOnly
+	CheckpointInterval  time.Duration        // write a keydir checkpoint this often and on Close; 0 disables
+	RecoveryWorkers     int                  // segments scanned in parallel on open; 0 means GOMAXPROCS
+	MaxKeydirBytes      int64                // Set of a new key, or an overwrite that retains a version, fails with KeydirFullErr past this estimate; 0 means no limit
+	KeydirOnDisk        bool                 // keep the keydir in an on-disk hash table (keydir.idx) instead of RAM; ordered reads sort it externally through scratch files in Dir
+	KeydirCacheBytes    int64                // bound on the KeydirOnDisk slot pages held in memory, mapped or cached; 0 means index.DefaultDiskCacheBytes
+	Indexes             map[string]IndexFunc // secondary indexes by name, rebuilt on open and queried with LookupIndex
+	ExpirySweepInterval time.Duration        // drop keys whose TTL has passed from the keydir this often; 0 disables
+	RetainVersions      VersionRetention     // keep superseded versions for GetAt/History; recovery then replays all segments
 }
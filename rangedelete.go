@@ -0,0 +1,90 @@
+package tiny_bitcask
+
+import (
+	"bytes"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+)
+
+// DeleteRange deletes every key in [start, end) by appending a single range
+// tombstone; a nil end deletes every key from start on. Keys under a reserved
+// prefix (collections, data structures) are skipped: a range spanning one is
+// split around it into up to three tombstones, written as one atomic batch.
+// Matching keys leave the keydir in one pass and each is reported to watchers
+// as OpDelete with its tombstone's sequence number. An empty range (end <=
+// start) writes nothing.
+func (db *DB) DeleteRange(start, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	return db.deleteRanges(unreservedRanges(start, end))
+}
+
+// deleteRanges writes a range tombstone for each of ranges, atomically, and
+// applies them. Reserved prefixes are not checked.
+func (db *DB) deleteRanges(ranges []keyRange) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	tombs := make([]*entity.Entry, len(ranges))
+	for i, r := range ranges {
+		tombs[i] = entity.NewRangeTombstone(r.start, r.end)
+	}
+	var hs []*entity.Hint
+	if len(tombs) == 1 {
+		h, err := db.storage.WriterEntity(tombs[0])
+		if err != nil {
+			return err
+		}
+		hs = []*entity.Hint{h}
+	} else {
+		var err error
+		if hs, err = db.storage.WriteBatch(tombs); err != nil {
+			return err
+		}
+	}
+	for i, r := range ranges {
+		e := tombs[i]
+		keys, err := db.deleteRange(r.start, r.end, db.tombstoneAt(hs[i], e))
+		if err == nil {
+			err = db.keydirErr()
+		}
+		if err != nil {
+			return err
+		}
+		db.counters.deletes.Add(uint64(len(keys)))
+		for _, k := range keys {
+			db.indexDelete([]byte(k))
+			db.watchers.emit([]byte(k), OpDelete, e.Meta.Seq)
+		}
+	}
+	return nil
+}
+
+// deleteRange drops the keys in [start, end) from the keydir, retiring each to
+// the history with the range tombstone tomb, and returns them in order. Caller
+// holds db.rw for writing.
+func (db *DB) deleteRange(start, end []byte, tomb *index.DataPosition) ([]string, error) {
+	keys, err := index.RangeKeys(db.kd, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		db.retire(k, tomb)
+		db.kd.Delete(k)
+	}
+	return keys, nil
+}
+
+// DeletePrefix deletes every key starting with prefix with one range
+// tombstone; an empty prefix deletes all keys outside the reserved prefixes
+// (see DeleteRange).
+func (db *DB) DeletePrefix(prefix []byte) error {
+	return db.DeleteRange(prefix, prefixEnd(prefix))
+}
@@ -0,0 +1,105 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tiny-bitcask/storage"
+)
+
+func keysOf(db *DB) []string {
+	var out []string
+	for _, k := range db.ListKeys() {
+		out = append(out, string(k))
+	}
+	return out
+}
+
+func TestDeleteRange_Bounds(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	for _, k := range []string{"a", "b", "b1", "c", "d"} {
+		require.NoError(t, db.Set([]byte(k), []byte("v")))
+	}
+
+	require.NoError(t, db.DeleteRange([]byte("b"), []byte("c")))
+	assert.Equal(t, []string{"a", "c", "d"}, keysOf(db))
+
+	require.NoError(t, db.DeleteRange([]byte("d"), []byte("a")))
+	assert.Equal(t, []string{"a", "c", "d"}, keysOf(db), "empty range is a no-op")
+
+	require.NoError(t, db.DeleteRange([]byte("c"), nil))
+	assert.Equal(t, []string{"a"}, keysOf(db))
+	assert.Equal(t, uint64(4), db.Stats().Deletes)
+}
+
+// TestDeletePrefix_Reopen checks the range tombstone is honoured by replay from
+// segments and from hint files, while keys written after it survive.
+func TestDeletePrefix_Reopen(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("t1:%03d", i)), []byte(fmt.Sprintf("%060d", i))))
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("t2:%03d", i)), []byte(fmt.Sprintf("%060d", i))))
+	}
+	require.NoError(t, db.DeletePrefix([]byte("t1:")))
+	require.NoError(t, db.Set([]byte("t1:new"), []byte("x")))
+	// Push the tombstone into a sealed segment so the next open reads its hint.
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("t3:%03d", i)), []byte(fmt.Sprintf("%060d", i))))
+	}
+	require.Greater(t, len(db.storage.GetOldFiles()), 2)
+
+	check := func(db *DB) {
+		for _, k := range db.ListKeys() {
+			if string(k[:3]) == "t1:" {
+				assert.Equal(t, "t1:new", string(k))
+			}
+		}
+		assert.Len(t, db.ListKeys(), 201)
+		_, err := db.Get([]byte("t1:050"))
+		assert.ErrorIs(t, err, KeyNotFoundErr)
+	}
+	check(db)
+
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	db, err := NewDB(&Options{Dir: dir, SegmentSize: 4 * storage.KB})
+	require.NoError(t, err)
+	check(db)
+
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+	db, err = NewDB(&Options{Dir: dir, SegmentSize: 4 * storage.KB})
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
+
+func TestDeletePrefix_IndexesAndReadOnly(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.Indexes = map[string]IndexFunc{"email": emailIndex}
+	})
+	require.NoError(t, db.Set([]byte("user:1"), []byte("Ann|ann@example.com")))
+	require.NoError(t, db.Set([]byte("admin:1"), []byte("Bob|bob@example.com")))
+	require.NoError(t, db.DeletePrefix([]byte("user:")))
+
+	keys, err := db.LookupIndex("email", []byte("ann@example.com"))
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	keys, err = db.LookupIndex("email", []byte("bob@example.com"))
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	ro, err := NewDB(&Options{Dir: dir, ReadOnly: true})
+	require.NoError(t, err)
+	defer ro.Close()
+	assert.ErrorIs(t, ro.DeletePrefix([]byte("admin:")), ReadOnlyDBErr)
+}
@@ -0,0 +1,41 @@
+package tiny_bitcask
+
+// ViewValue calls fn with key's value in a buffer borrowed from an internal
+// pool, avoiding the copy Get makes. The slice is only valid until fn returns
+// and must not be retained or modified. fn runs without the DB lock held, so
+// it may call back into the DB. (The name View is taken by read-only
+// transactions.)
+func (db *DB) ViewValue(key []byte, fn func(value []byte) error) error {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	dp := db.find(string(key))
+	if dp == nil {
+		db.rw.RUnlock()
+		return KeyNotFoundErr
+	}
+	value, release, err := db.storage.ReadValue(dp)
+	db.rw.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(value)
+}
+
+// GetInto appends key's value to dst[:0] and returns the result, reusing dst's
+// capacity when it is large enough.
+func (db *DB) GetInto(key []byte, dst []byte) ([]byte, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	dp := db.find(string(key))
+	if dp == nil {
+		return dst[:0], KeyNotFoundErr
+	}
+	value, release, err := db.storage.ReadValue(dp)
+	if err != nil {
+		return dst[:0], err
+	}
+	defer release()
+	return append(dst[:0], value...), nil
+}
@@ -0,0 +1,51 @@
+package tiny_bitcask
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViewValue_GetInto(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("a"), []byte("hello")))
+
+	var seen string
+	require.NoError(t, db.ViewValue([]byte("a"), func(v []byte) error {
+		seen = string(v)
+		// The DB lock is not held during the callback.
+		return db.Set([]byte("b"), []byte("from-callback"))
+	}))
+	assert.Equal(t, "hello", seen)
+	assert.True(t, db.Has([]byte("b")))
+
+	boom := errors.New("boom")
+	assert.ErrorIs(t, db.ViewValue([]byte("a"), func([]byte) error { return boom }), boom)
+	assert.ErrorIs(t, db.ViewValue([]byte("nope"), func([]byte) error { return nil }), KeyNotFoundErr)
+
+	buf := make([]byte, 0, 64)
+	got, err := db.GetInto([]byte("a"), buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+	assert.Equal(t, &buf[:1][0], &got[0], "GetInto reuses dst")
+	_, err = db.GetInto([]byte("nope"), buf)
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+}
+
+func TestGetInto_Allocs(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("key"), make([]byte, 1024)))
+	key, buf := []byte("key"), make([]byte, 0, 2048)
+	allocs := testing.AllocsPerRun(100, func() {
+		var err error
+		buf, err = db.GetInto(key, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.LessOrEqual(t, allocs, 2.0)
+}
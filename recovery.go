@@ -0,0 +1,332 @@
+package tiny_bitcask
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+	"tiny-bitcask/storage"
+)
+
+// recoveredRecord is one keydir mutation decoded from a segment or its hint file.
+type recoveredRecord struct {
+	key       []byte
+	delete    bool
+	rangeDel  bool // range tombstone: keys in [key, rangeEnd) are deleted
+	rangeEnd  []byte
+	past      bool // VersionFlag / VersionDeleteFlag copy of a retained version
+	off       int64
+	timestamp uint64
+	keySize   int
+	valueSize int
+	expiry    uint64
+	seq       uint64
+}
+
+// segmentJob describes which part of a segment recovery must replay.
+type segmentJob struct {
+	fid      int
+	isActive bool
+	from     int64
+}
+
+// segmentResult carries a scanned segment from a worker to the applier.
+type segmentResult struct {
+	recs []recoveredRecord
+	end  int64
+	seq  uint64 // highest sequence number the segment or its hint accounts for
+	err  error
+	done chan struct{}
+}
+
+// recovery  will rebuild a db from existing dir
+func (db *DB) recovery(opt *Options) (err error) {
+	if !opt.ReadOnly {
+		if err := storage.RemoveObsoleteFiles(opt.Dir); err != nil {
+			return err
+		}
+	}
+	var fileSize = getSegmentSize(opt.SegmentSize)
+	db.storage, err = storage.NewDataFileWithFiles(opt.Dir, fileSize, opt.VerifyCRC, opt.ReadOnly)
+	if err != nil {
+		return err
+	}
+	fids, err := storage.ListDataFileIDs(opt.Dir)
+	if err != nil {
+		return err
+	}
+	// Retained history is rebuilt from the segments themselves, so a keydir
+	// snapshot cannot shortcut replay.
+	var startFid int
+	var startOff int64
+	var startSeq uint64
+	var haveStart bool
+	if db.hist == nil {
+		startFid, startOff, startSeq, haveStart, err = db.recoveryStart(opt.Dir, fids)
+		if err != nil {
+			return err
+		}
+	}
+	db.storage.SetSeq(startSeq)
+	jobs := make([]segmentJob, 0, len(fids))
+	for i, fid := range fids {
+		job := segmentJob{fid: fid, isActive: i == len(fids)-1}
+		if haveStart {
+			if fid < startFid {
+				continue
+			}
+			if fid == startFid {
+				job.from = startOff
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	if err := db.replaySegments(jobs, getRecoveryWorkers(opt.RecoveryWorkers)); err != nil {
+		return err
+	}
+	if err := db.keydirErr(); err != nil {
+		return err
+	}
+	return db.rebuildIndexes()
+}
+
+// recoveryStart decides where replay begins: at the position a cleanly flushed
+// on-disk keydir covers, else at a usable checkpoint, else at the first segment.
+// seq is the sequence high-water mark recorded with that position. An on-disk
+// keydir that cannot be trusted is reset first.
+func (db *DB) recoveryStart(dir string, fids []int) (fid int, off int64, seq uint64, ok bool, err error) {
+	if p, isPersistent := db.kd.(index.Persistent); isPersistent {
+		fid, off, seq, ok = p.Covered()
+		if ok {
+			sizes, err := segmentSizes(dir, fids)
+			if err == nil {
+				if size, exist := sizes[fid]; exist && off >= 0 && off <= size {
+					return fid, off, seq, true, nil
+				}
+			}
+		}
+		if err := p.Reset(); err != nil {
+			return 0, 0, 0, false, err
+		}
+	}
+	cp, ok := db.loadCheckpoint(dir, fids)
+	if !ok {
+		return 0, 0, 0, false, nil
+	}
+	return cp.Fid, cp.Off, cp.Seq, true, nil
+}
+
+// segmentSizes stats every segment in fids.
+func segmentSizes(dir string, fids []int) (map[int]int64, error) {
+	sizes := make(map[int]int64, len(fids))
+	for _, fid := range fids {
+		st, err := os.Stat(storage.DataFilePath(dir, fid))
+		if err != nil {
+			return nil, err
+		}
+		sizes[fid] = st.Size()
+	}
+	return sizes, nil
+}
+
+// replaySegments scans segments on up to workers goroutines and applies the
+// results to the keydir strictly in fid order, so later records and tombstones
+// still win. A scanned segment holds its worker slot until it has been applied,
+// which bounds how far scanning can run ahead of the keydir.
+func (db *DB) replaySegments(jobs []segmentJob, workers int) error {
+	results := make([]*segmentResult, len(jobs))
+	for i := range results {
+		results[i] = &segmentResult{done: make(chan struct{})}
+	}
+	sem := make(chan struct{}, workers)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, job := range jobs {
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+			wg.Add(1)
+			go func(r *segmentResult, job segmentJob) {
+				defer wg.Done()
+				r.recs, r.end, r.seq, r.err = db.scanSegment(job.fid, db.opt.Dir, job.isActive, db.opt.VerifyCRC, job.from)
+				close(r.done)
+			}(results[i], job)
+		}
+	}()
+
+	var err error
+	for i, job := range jobs {
+		r := results[i]
+		<-r.done
+		if r.err != nil {
+			err = r.err
+			break
+		}
+		if err = db.applyRecovered(job.fid, r.recs); err != nil {
+			break
+		}
+		db.storage.SetSeq(r.seq)
+		if job.isActive && !db.opt.ReadOnly {
+			// Drop a torn tail (partial record or uncommitted batch) so new
+			// appends are reachable by the next scan.
+			if err = db.storage.TruncateActive(r.end); err != nil {
+				break
+			}
+		}
+		results[i] = nil
+		<-sem
+	}
+	close(stop)
+	wg.Wait()
+	return err
+}
+
+// applyRecovered applies one segment's records; a record whose TTL has already
+// passed removes the key like a tombstone, since it still supersedes older ones.
+// With history on, superseded records are retired as they would have been
+// when written. It fails only if a range tombstone cannot read the keydir.
+func (db *DB) applyRecovered(fid int, recs []recoveredRecord) error {
+	now := time.Now().UnixNano()
+	for _, r := range recs {
+		var pos *index.DataPosition
+		if db.hist != nil {
+			pos = &index.DataPosition{Fid: fid, Off: r.off, Timestamp: r.timestamp, KeySize: r.keySize, ValueSize: r.valueSize, Expiry: r.expiry, Seq: r.seq}
+		}
+		key := string(r.key)
+		switch {
+		case r.past:
+			if db.hist != nil {
+				db.hist.insert(key, pastVersion{dp: pos, deleted: r.delete})
+				db.hist.prune(key, 0)
+			}
+		case r.rangeDel:
+			if _, err := db.deleteRange(r.key, r.rangeEnd, pos); err != nil {
+				return err
+			}
+		case r.delete:
+			db.retire(key, pos)
+			db.kd.Delete(key)
+		case r.expiry != 0 && r.expiry <= uint64(now):
+			db.retire(key, nil)
+			if db.hist != nil {
+				db.hist.insert(key, pastVersion{dp: pos})
+			}
+			db.kd.Delete(key)
+		default:
+			db.retire(key, nil)
+			index.AddIndexBySizes(db.kd, fid, r.off, r.key, r.keySize, r.valueSize, r.timestamp, r.expiry, r.seq)
+		}
+	}
+	return nil
+}
+
+// scanSegment decodes segment fid starting at byte offset from, preferring its
+// hint file for a sealed segment read from the start. end is the offset just
+// past the last committed record scanned (0 when the hint was used). seq is
+// the highest sequence number among the records scanned or in the hint header,
+// which also covers records merge has since dropped from older segments.
+func (db *DB) scanSegment(fid int, dir string, isActive bool, verifyCRC bool, from int64) (recs []recoveredRecord, end int64, seq uint64, err error) {
+	if !isActive && storage.HintFileExists(dir, fid) {
+		hrs, hseq, err := storage.ReadHintFile(dir, fid)
+		if err == nil {
+			seq = hseq
+			for _, r := range hrs {
+				seq = max(seq, r.Seq)
+			}
+			if from == 0 && db.hist == nil {
+				if recs, err := db.hintRecords(fid, dir, hrs); err == nil {
+					return recs, 0, seq, nil
+				}
+			}
+		}
+	}
+
+	of, err := storage.NewOldFile(dir, fid, verifyCRC)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer of.Close()
+	scan := of.Scan
+	if isActive {
+		scan = of.ScanActive
+	}
+	end, err = scan(from, func(off int64, entry *entity.Entry) error {
+		seq = max(seq, entry.Meta.Seq)
+		flag := entry.Meta.Flag
+		rec := recoveredRecord{
+			key:       entry.Key,
+			delete:    flag == entity.DeleteFlag || flag == entity.VersionDeleteFlag,
+			past:      flag == entity.VersionFlag || flag == entity.VersionDeleteFlag,
+			off:       off,
+			timestamp: entry.Meta.TimeStamp,
+			keySize:   len(entry.Key),
+			valueSize: len(entry.Value),
+			expiry:    entry.Meta.Expiry,
+			seq:       entry.Meta.Seq,
+		}
+		if flag == entity.RangeDeleteFlag {
+			rec.rangeDel, rec.rangeEnd = true, entry.Value
+		}
+		recs = append(recs, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return recs, end, seq, nil
+}
+
+// hintRecords converts the rows of segment fid's hint file, checking they fit
+// the segment.
+func (db *DB) hintRecords(fid int, dir string, hrs []storage.HintRecord) ([]recoveredRecord, error) {
+	datPath := storage.DataFilePath(dir, fid)
+	st, err := os.Stat(datPath)
+	if err != nil {
+		return nil, err
+	}
+	datSize := st.Size()
+	recs := make([]recoveredRecord, 0, len(hrs))
+	for _, r := range hrs {
+		if r.Flag == entity.DeleteFlag {
+			recs = append(recs, recoveredRecord{key: r.Key, delete: true, seq: r.Seq})
+			continue
+		}
+		if r.Flag == entity.RangeDeleteFlag {
+			recs = append(recs, recoveredRecord{key: r.Key, rangeDel: true, rangeEnd: r.End, seq: r.Seq})
+			continue
+		}
+		if int(r.KeySize) != len(r.Key) {
+			return nil, errors.New("hint key length mismatch")
+		}
+		recLen := db.storage.RecordLen(fid, int(r.KeySize), int(r.ValueSize))
+		if r.RecordOffset < 0 || r.RecordOffset+recLen > datSize {
+			return nil, errors.New("hint record out of range for data file")
+		}
+		recs = append(recs, recoveredRecord{
+			key:       r.Key,
+			off:       r.RecordOffset,
+			timestamp: r.Timestamp,
+			keySize:   int(r.KeySize),
+			valueSize: int(r.ValueSize),
+			expiry:    r.Expiry,
+			seq:       r.Seq,
+		})
+	}
+	return recs, nil
+}
+
+func getRecoveryWorkers(n int) int {
+	if n <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return n
+}
@@ -0,0 +1,74 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/storage"
+)
+
+// TestDB_Recovery_ParallelMatchesSequential reopens a many-segment store with
+// different worker counts; overwrites and tombstones spread across segments
+// must resolve to the same keydir as a strictly ordered (one worker) replay.
+func TestDB_Recovery_ParallelMatchesSequential(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "parallel")
+	opt := *DefaultOptions
+	opt.Dir = dataDir
+	opt.SegmentSize = 2 * storage.KB
+
+	db1, err := NewDB(&opt)
+	require.NoError(t, err)
+	live := map[string]bool{}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("k_%d", i)
+			if (i+round)%7 == 0 {
+				if live[key] {
+					require.NoError(t, db1.Delete([]byte(key)))
+					delete(live, key)
+				}
+				continue
+			}
+			require.NoError(t, db1.Set([]byte(key), []byte(fmt.Sprintf("v_%d_%d", i, round))))
+			live[key] = true
+		}
+	}
+	require.NoError(t, db1.Close())
+	fids, err := storage.ListDataFileIDs(dataDir)
+	require.NoError(t, err)
+	require.Greater(t, len(fids), 5)
+
+	fold := func(t *testing.T, workers int) map[string]string {
+		t.Helper()
+		o := opt
+		o.RecoveryWorkers = workers
+		db, err := NewDB(&o)
+		require.NoError(t, err)
+		defer db.Close()
+		out := map[string]string{}
+		require.NoError(t, db.Fold(func(k, v []byte) error {
+			out[string(k)] = string(v)
+			return nil
+		}))
+		return out
+	}
+	want := fold(t, 1)
+	assert.Equal(t, "v_99_4", want["k_99"])
+
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{name: "two_workers", workers: 2},
+		{name: "more_workers_than_segments", workers: 64},
+		{name: "default_gomaxprocs", workers: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, want, fold(t, tt.workers))
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package tiny_bitcask
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"tiny-bitcask/entity"
+)
+
+var (
+	ReservedKeyErr = errors.New("key starts with a reserved prefix")
+)
+
+// reservedPrefixes start the keys owned by Collection and by the hash, set,
+// list and sorted set operations, in key order. The plain write API (Set,
+// SetWithTTL, SetReader, SetIfVersion, CompareAndDelete, Delete, IncrBy,
+// WriteBatch and Txn writes) rejects such keys with ReservedKeyErr, and
+// DeleteRange and DeletePrefix leave them alone, as do the Keys, All, Scan
+// and Entries iterators, cursors and snapshot iteration. Point reads are not
+// restricted.
+var reservedPrefixes = []string{collectionKeyPrefix, dsKeyPrefix}
+
+// checkKey returns ReservedKeyErr if key starts with a reserved prefix.
+func checkKey(key []byte) error {
+	for _, p := range reservedPrefixes {
+		if strings.HasPrefix(string(key), p) {
+			return ReservedKeyErr
+		}
+	}
+	return nil
+}
+
+// checkEntryKeys is checkKey for every entry of a batch.
+func checkEntryKeys(entries []*entity.Entry) error {
+	for _, e := range entries {
+		if err := checkKey(e.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyRange is [start, end); an empty end leaves it unbounded above.
+type keyRange struct {
+	start, end []byte
+}
+
+// unreservedRanges splits [start, end) into the ranges left after cutting out
+// every reserved prefix.
+func unreservedRanges(start, end []byte) []keyRange {
+	var out []keyRange
+	for _, p := range reservedPrefixes {
+		ps, pe := []byte(p), prefixEnd([]byte(p))
+		if len(end) > 0 && bytes.Compare(end, ps) <= 0 {
+			break
+		}
+		if bytes.Compare(start, ps) < 0 {
+			out = append(out, keyRange{start, ps})
+		}
+		if bytes.Compare(start, pe) < 0 {
+			start = pe
+		}
+	}
+	if len(end) == 0 || bytes.Compare(start, end) < 0 {
+		out = append(out, keyRange{start, end})
+	}
+	return out
+}
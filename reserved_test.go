@@ -0,0 +1,114 @@
+package tiny_bitcask
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserved_PlainWritesRejected(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	writes := map[string]func(key []byte) error{
+		"set":         func(k []byte) error { return db.Set(k, []byte("v")) },
+		"set_context": func(k []byte) error { return db.SetContext(context.Background(), k, []byte("v")) },
+		"set_ttl":     func(k []byte) error { return db.SetWithTTL(k, []byte("v"), time.Hour) },
+		"set_reader":  func(k []byte) error { return db.SetReader(k, bytes.NewReader([]byte("v")), 1) },
+		"set_if_absent": func(k []byte) error {
+			_, err := db.SetIfAbsent(k, []byte("v"))
+			return err
+		},
+		"compare_and_delete": func(k []byte) error { return db.CompareAndDelete(k, NoVersion) },
+		"delete":             func(k []byte) error { return db.Delete(k) },
+		"incr": func(k []byte) error {
+			_, err := db.IncrBy(k, 1)
+			return err
+		},
+		"batch": func(k []byte) error {
+			b := NewWriteBatch()
+			b.Set([]byte("plain"), []byte("v"))
+			b.Delete(k)
+			return db.Write(b)
+		},
+		"txn": func(k []byte) error {
+			return db.Update(func(tx *Txn) error { return tx.Set(k, []byte("v")) })
+		},
+	}
+	for name, write := range writes {
+		t.Run(name, func(t *testing.T) {
+			for _, p := range reservedPrefixes {
+				assert.ErrorIs(t, write([]byte(p+"x")), ReservedKeyErr)
+			}
+		})
+	}
+	assert.False(t, db.Has([]byte("plain")), "a rejected batch writes nothing")
+}
+
+func TestReserved_RangeDeleteSkipsReserved(t *testing.T) {
+	db := newTestDB(t, nil)
+	users, err := NewCollection(db, "users", StringCodec{}, StringCodec{})
+	require.NoError(t, err)
+	require.NoError(t, users.Put("ann", "admin"))
+	require.NoError(t, db.HSet([]byte("h"), []byte("f"), []byte("v")))
+	plain := []string{"\x00", "\x00a", "\x00d", "\x00z", "plain"}
+	for _, k := range plain {
+		require.NoError(t, db.Set([]byte(k), []byte("v")))
+	}
+
+	require.NoError(t, db.DeletePrefix(nil))
+	check := func(db *DB) {
+		t.Helper()
+		for _, k := range plain {
+			assert.False(t, db.Has([]byte(k)), k)
+		}
+		got, err := users.Get("ann")
+		require.NoError(t, err)
+		assert.Equal(t, "admin", got)
+		v, err := db.HGet([]byte("h"), []byte("f"))
+		require.NoError(t, err)
+		assert.Equal(t, "v", string(v))
+	}
+	check(db)
+
+	o := *db.opt
+	require.NoError(t, db.Close())
+	db, err = NewDB(&o)
+	require.NoError(t, err)
+	defer db.Close()
+	users.db = db
+	check(db)
+
+	require.NoError(t, users.DeleteAll())
+	_, err = users.Get("ann")
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+}
+
+func TestReserved_UnreservedRanges(t *testing.T) {
+	col, ds := collectionKeyPrefix, dsKeyPrefix
+	colEnd, dsEnd := string(prefixEnd([]byte(col))), string(prefixEnd([]byte(ds)))
+	tests := []struct {
+		name       string
+		start, end string
+		want       []string // start and end of each range
+	}{
+		{name: "all", start: "", end: "", want: []string{"", col, colEnd, ds, dsEnd, ""}},
+		{name: "below_both", start: "", end: "\x00a", want: []string{"", "\x00a"}},
+		{name: "above_both", start: "a", end: "b", want: []string{"a", "b"}},
+		{name: "ends_inside_first", start: "", end: col + "x", want: []string{"", col}},
+		{name: "between", start: "\x00col\x00z", end: "\x00e", want: []string{colEnd, ds, dsEnd, "\x00e"}},
+		{name: "inside_prefix", start: ds + "a", end: ds + "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range unreservedRanges([]byte(tt.start), []byte(tt.end)) {
+				got = append(got, string(r.start), string(r.end))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
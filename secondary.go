@@ -0,0 +1,129 @@
+package tiny_bitcask
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	IndexNotFoundErr = errors.New("secondary index not found")
+)
+
+// IndexFunc extracts the terms a record should be findable by in a secondary
+// index. It is called with the key and value of every live record and must not
+// retain either slice. Returning no terms leaves the key out of the index.
+type IndexFunc func(key, value []byte) [][]byte
+
+// secondaryIndex is an in-memory term → keys mapping kept in step with the
+// keydir. byKey remembers each key's terms so an overwrite or delete can
+// retract them without reading the old value.
+type secondaryIndex struct {
+	fn    IndexFunc
+	terms map[string]map[string]struct{}
+	byKey map[string][]string
+}
+
+func newSecondaryIndexes(fns map[string]IndexFunc) map[string]*secondaryIndex {
+	out := make(map[string]*secondaryIndex, len(fns))
+	for name, fn := range fns {
+		out[name] = &secondaryIndex{
+			fn:    fn,
+			terms: map[string]map[string]struct{}{},
+			byKey: map[string][]string{},
+		}
+	}
+	return out
+}
+
+func (si *secondaryIndex) put(key, value []byte) {
+	k := string(key)
+	si.remove(k)
+	raw := si.fn(key, value)
+	if len(raw) == 0 {
+		return
+	}
+	terms := make([]string, 0, len(raw))
+	for _, t := range raw {
+		term := string(t)
+		keys, ok := si.terms[term]
+		if !ok {
+			keys = map[string]struct{}{}
+			si.terms[term] = keys
+		}
+		if _, dup := keys[k]; dup {
+			continue
+		}
+		keys[k] = struct{}{}
+		terms = append(terms, term)
+	}
+	si.byKey[k] = terms
+}
+
+func (si *secondaryIndex) remove(k string) {
+	for _, term := range si.byKey[k] {
+		keys := si.terms[term]
+		delete(keys, k)
+		if len(keys) == 0 {
+			delete(si.terms, term)
+		}
+	}
+	delete(si.byKey, k)
+}
+
+// indexPut refreshes every secondary index for key. Caller holds db.rw for writing.
+func (db *DB) indexPut(key, value []byte) {
+	for _, si := range db.indexes {
+		si.put(key, value)
+	}
+}
+
+// indexDelete retracts key from every secondary index. Caller holds db.rw for writing.
+func (db *DB) indexDelete(key []byte) {
+	k := string(key)
+	for _, si := range db.indexes {
+		si.remove(k)
+	}
+}
+
+// rebuildIndexes fills the secondary indexes from the recovered keydir by
+// reading every live value once.
+func (db *DB) rebuildIndexes() error {
+	if len(db.indexes) == 0 {
+		return nil
+	}
+	return db.eachKey(func(k string) error {
+		dp := db.kd.Find(k)
+		if dp == nil {
+			return nil
+		}
+		entry, err := db.storage.ReadEntry(dp)
+		if err != nil {
+			return err
+		}
+		db.indexPut(entry.Key, entry.Value)
+		return nil
+	})
+}
+
+// LookupIndex returns, in lexicographic order, the keys whose current value
+// produced term in the secondary index registered as name in Options.Indexes.
+func (db *DB) LookupIndex(name string, term []byte) ([][]byte, error) {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	si, ok := db.indexes[name]
+	if !ok {
+		return nil, IndexNotFoundErr
+	}
+	keys := make([]string, 0, len(si.terms[string(term)]))
+	for k := range si.terms[string(term)] {
+		if db.find(k) != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	out := make([][]byte, len(keys))
+	for i, k := range keys {
+		out[i] = []byte(k)
+	}
+	return out, nil
+}
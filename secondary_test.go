@@ -0,0 +1,128 @@
+package tiny_bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/storage"
+)
+
+// emailIndex indexes values of the form "name|email" by their email part.
+func emailIndex(_, value []byte) [][]byte {
+	i := bytes.IndexByte(value, '|')
+	if i < 0 {
+		return nil
+	}
+	return [][]byte{value[i+1:]}
+}
+
+func keyStrings(keys [][]byte) []string {
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = string(k)
+	}
+	return out
+}
+
+func TestDB_LookupIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, db *DB)
+		term string
+		want []string
+	}{
+		{
+			name: "set_indexes_value_terms",
+			run: func(t *testing.T, db *DB) {
+				require.NoError(t, db.Set([]byte("u2"), []byte("bob|shared@x")))
+				require.NoError(t, db.Set([]byte("u1"), []byte("ann|shared@x")))
+				require.NoError(t, db.Set([]byte("u3"), []byte("cat|cat@x")))
+			},
+			term: "shared@x",
+			want: []string{"u1", "u2"},
+		},
+		{
+			name: "overwrite_retracts_old_term",
+			run: func(t *testing.T, db *DB) {
+				require.NoError(t, db.Set([]byte("u1"), []byte("ann|old@x")))
+				require.NoError(t, db.Set([]byte("u1"), []byte("ann|new@x")))
+			},
+			term: "old@x",
+			want: []string{},
+		},
+		{
+			name: "delete_retracts_term",
+			run: func(t *testing.T, db *DB) {
+				require.NoError(t, db.Set([]byte("u1"), []byte("ann|a@x")))
+				require.NoError(t, db.Set([]byte("u2"), []byte("bob|a@x")))
+				require.NoError(t, db.Delete([]byte("u1")))
+			},
+			term: "a@x",
+			want: []string{"u2"},
+		},
+		{
+			name: "values_without_terms_are_skipped",
+			run: func(t *testing.T, db *DB) {
+				require.NoError(t, db.Set([]byte("u1"), []byte("no-email")))
+			},
+			term: "no-email",
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, func(o *Options) {
+				o.Indexes = map[string]IndexFunc{"email": emailIndex}
+			})
+			defer db.Close()
+			tt.run(t, db)
+			keys, err := db.LookupIndex("email", []byte(tt.term))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keyStrings(keys))
+		})
+	}
+}
+
+func TestDB_LookupIndex_UnknownName(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	_, err := db.LookupIndex("email", []byte("a@x"))
+	assert.ErrorIs(t, err, IndexNotFoundErr)
+}
+
+// TestDB_LookupIndex_RebuiltOnOpenAndKeptByMerge checks the index is rebuilt
+// from segment data on reopen and still answers after merge moves records.
+func TestDB_LookupIndex_RebuiltOnOpenAndKeptByMerge(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "secondary")
+	opt := *DefaultOptions
+	opt.Dir = dataDir
+	opt.SegmentSize = 4 * storage.KB
+	opt.Indexes = map[string]IndexFunc{"email": emailIndex}
+
+	db1, err := NewDB(&opt)
+	require.NoError(t, err)
+	require.NoError(t, db1.Set([]byte("u1"), []byte("ann|team@x")))
+	for i := 0; i < 500; i++ {
+		require.NoError(t, db1.Set([]byte("busy"), []byte(fmt.Sprintf("b|busy%d@x", i))))
+	}
+	require.NoError(t, db1.Close())
+
+	db2, err := NewDB(&opt)
+	require.NoError(t, err)
+	defer db2.Close()
+	keys, err := db2.LookupIndex("email", []byte("team@x"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, keyStrings(keys))
+
+	require.NoError(t, db2.Merge())
+	keys, err = db2.LookupIndex("email", []byte("busy499@x"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"busy"}, keyStrings(keys))
+	keys, err = db2.LookupIndex("email", []byte("busy0@x"))
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
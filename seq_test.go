@@ -0,0 +1,114 @@
+package tiny_bitcask
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tiny-bitcask/storage"
+)
+
+func TestSeq_OrdersWrites(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	ch, err := db.Watch(context.Background(), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(0), db.LastSeq())
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	require.NoError(t, db.Set([]byte("b"), []byte("2")))
+	b := NewWriteBatch()
+	b.Set([]byte("c"), []byte("3"))
+	b.Delete([]byte("a"))
+	require.NoError(t, db.Write(b))
+	require.NoError(t, db.DeletePrefix([]byte("b")))
+
+	var seqs []uint64
+	for _, ev := range drain(ch, 4, t) {
+		seqs = append(seqs, ev.Seq)
+	}
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5}, append(seqs, db.LastSeq()))
+	info, err := db.Stat([]byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), info.Seq)
+	assert.Equal(t, Version(3), info.Version)
+}
+
+// TestSeq_Recovered checks the counter resumes past every earlier write,
+// including a trailing delete, whichever way the keydir is rebuilt.
+func TestSeq_Recovered(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  func(*Options)
+	}{
+		{name: "scan", opt: func(o *Options) {}},
+		{name: "hints", opt: func(o *Options) { o.SegmentSize = storage.KB }},
+		{name: "checkpoint", opt: func(o *Options) { o.CheckpointInterval = 1 << 40 }},
+		{name: "keydir_on_disk", opt: func(o *Options) { o.KeydirOnDisk = true }},
+		{name: "history", opt: func(o *Options) { o.RetainVersions = VersionRetention{Count: 1} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, tt.opt)
+			for i := 0; i < 50; i++ {
+				require.NoError(t, db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte("value")))
+			}
+			require.NoError(t, db.Delete([]byte("k07")))
+			last := db.LastSeq()
+			k3, err := db.Stat([]byte("k03"))
+			require.NoError(t, err)
+
+			o := *db.opt
+			require.NoError(t, db.Close())
+			db, err = NewDB(&o)
+			require.NoError(t, err)
+			defer db.Close()
+			assert.Equal(t, last, db.LastSeq())
+			got, err := db.Stat([]byte("k03"))
+			require.NoError(t, err)
+			assert.Equal(t, k3.Seq, got.Seq)
+			require.NoError(t, db.Set([]byte("new"), []byte("v")))
+			info, err := db.Stat([]byte("new"))
+			require.NoError(t, err)
+			assert.Equal(t, last+1, info.Seq)
+		})
+	}
+}
+
+// TestSeq_SurvivesMerge checks merge keeps each record's seq, so versions
+// stay valid, and that the high-water mark survives merge dropping the
+// segment holding the latest write.
+func TestSeq_SurvivesMerge(t *testing.T) {
+	db := newTestDB(t, func(o *Options) { o.SegmentSize = 4 * storage.KB })
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("live-%03d", i)), []byte(fmt.Sprintf("%060d", i))))
+	}
+	_, ver, err := db.GetWithVersion([]byte("live-000"))
+	require.NoError(t, err)
+	// End on a tombstone that seals its segment.
+	sealed := len(db.storage.GetOldFiles())
+	for i := 0; len(db.storage.GetOldFiles()) == sealed; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("gone-%03d", i)), []byte("x")))
+		require.NoError(t, db.Delete([]byte(fmt.Sprintf("gone-%03d", i))))
+	}
+	last := db.LastSeq()
+	olds := db.storage.GetOldFiles()
+	tombFid := olds[len(olds)-1]
+
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Merge())
+	require.False(t, slices.Contains(db.storage.GetOldFiles(), tombFid))
+
+	o := *db.opt
+	require.NoError(t, db.Close())
+	db, err = NewDB(&o)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, last, db.LastSeq())
+	_, err = db.SetIfVersion([]byte("live-000"), []byte("new"), ver)
+	assert.NoError(t, err, "merge must not change versions")
+}
@@ -0,0 +1,222 @@
+package tiny_bitcask
+
+import (
+	"errors"
+	"iter"
+	"sync"
+	"time"
+
+	"tiny-bitcask/index"
+)
+
+var (
+	SnapshotReleasedErr = errors.New("snapshot released")
+	DBClosedErr         = errors.New("database closed")
+)
+
+// Snapshot is a read-only view of the DB frozen at the moment it was taken.
+// It holds its own sorted copy of the keydir and pins every segment that copy
+// points into, so merge can run (the segments are deleted on Release) and
+// writers are never blocked by a live Snapshot. Over an on-disk keydir a large
+// copy spills to a scratch file in Options.Dir. Release it when done. Safe for
+// concurrent use.
+type Snapshot struct {
+	db   *DB
+	kd   *index.Run
+	fids []int
+	seq  uint64
+
+	mu       sync.RWMutex // held for reading by readers of kd and segments, for writing by Release
+	released bool
+}
+
+// Snapshot captures the current keydir. The copy costs O(keys) under the read lock.
+func (db *DB) Snapshot() (*Snapshot, error) {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	if db.storage == nil {
+		return nil, DBClosedErr
+	}
+	s := &Snapshot{db: db, seq: db.storage.Seq()}
+	seen := map[int]bool{}
+	now := time.Now().UnixNano()
+	kd, err := index.NewRun(db.kd, "", "", db.spillDir(), func(_ string, dp *index.DataPosition) bool {
+		if dp.Expired(now) {
+			return false
+		}
+		if !seen[dp.Fid] {
+			seen[dp.Fid] = true
+			s.fids = append(s.fids, dp.Fid)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.kd = kd
+	for _, fid := range s.fids {
+		db.storage.Pin(fid)
+	}
+	return s, nil
+}
+
+// find returns key's position in the snapshot, or nil. Caller holds s.mu.
+func (s *Snapshot) find(key string) (*index.DataPosition, error) {
+	if s.released {
+		return nil, SnapshotReleasedErr
+	}
+	i, err := s.kd.Search(key)
+	if err != nil || i == s.kd.Len() {
+		return nil, err
+	}
+	k, dp, err := s.kd.At(i)
+	if err != nil || k != key {
+		return nil, err
+	}
+	return dp, nil
+}
+
+// keyAt returns the i-th key of the snapshot.
+func (s *Snapshot) keyAt(i int) (string, *index.DataPosition, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return "", nil, SnapshotReleasedErr
+	}
+	return s.kd.At(i)
+}
+
+// Get returns key's value as of the snapshot.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	s.db.counters.gets.Add(1)
+	// Holding mu keeps Release from unpinning, and so merge from deleting, the
+	// segment until the read is done.
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dp, err := s.find(string(key))
+	if err != nil {
+		return nil, err
+	}
+	if dp == nil {
+		return nil, KeyNotFoundErr
+	}
+	s.db.rw.RLock()
+	defer s.db.rw.RUnlock()
+	if s.db.storage == nil {
+		return nil, DBClosedErr
+	}
+	entry, err := s.db.storage.ReadEntry(dp)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+// Has reports whether key existed when the snapshot was taken; it reports
+// false once the snapshot is released.
+func (s *Snapshot) Has(key []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dp, _ := s.find(string(key))
+	return dp != nil
+}
+
+// Seq returns the last sequence number written before the snapshot was taken:
+// it reflects every write up to Seq and none after.
+func (s *Snapshot) Seq() uint64 {
+	return s.seq
+}
+
+// Len returns the number of keys in the snapshot.
+func (s *Snapshot) Len() int {
+	return s.kd.Len()
+}
+
+// Keys yields the snapshot's keys in lexicographic order, leaving out reserved
+// keys as DB.Keys does.
+func (s *Snapshot) Keys() iter.Seq[[]byte] {
+	return seqKeys(s.rangeKeys(nil, nil))
+}
+
+// All yields every key and value in the snapshot; see Scan.
+func (s *Snapshot) All() iter.Seq2[[]byte, []byte] {
+	return s.Scan(nil, nil)
+}
+
+// Scan yields keys in [start, end) with their snapshot values, stopping at the
+// first read error; use Entries to observe it.
+func (s *Snapshot) Scan(start, end []byte) iter.Seq2[[]byte, []byte] {
+	return seqValues(s.Entries(start, end))
+}
+
+// Entries is the error-reporting form of Scan.
+func (s *Snapshot) Entries(start, end []byte) iter.Seq2[KV, error] {
+	return seqEntries(s.rangeKeys(start, end), s.Get)
+}
+
+// rangeKeys yields the snapshot's keys in [start, end), leaving out reserved
+// keys as DB.Keys does; a nil bound is open.
+func (s *Snapshot) rangeKeys(start, end []byte) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if end != nil && string(end) <= string(start) {
+			return
+		}
+		for _, r := range unreservedRanges(start, end) {
+			lo, hi, err := s.bounds(r.start, r.end)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for k, err := range seqRun(lo, hi, s.keyAt) {
+				if !yield(k, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// bounds returns the positions of [start, end) in the snapshot; an empty end
+// is open.
+func (s *Snapshot) bounds(start, end []byte) (lo, hi int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return 0, 0, SnapshotReleasedErr
+	}
+	if lo, err = s.kd.Search(string(start)); err != nil {
+		return 0, 0, err
+	}
+	hi = s.kd.Len()
+	if len(end) > 0 {
+		if hi, err = s.kd.Search(string(end)); err != nil {
+			return 0, 0, err
+		}
+	}
+	return lo, max(lo, hi), nil
+}
+
+// Release unpins the snapshot's segments once reads in progress finish;
+// further reads fail with SnapshotReleasedErr. Calling Release more than once
+// is a no-op.
+func (s *Snapshot) Release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return nil
+	}
+	s.released = true
+	first := s.kd.Close()
+
+	s.db.rw.Lock()
+	defer s.db.rw.Unlock()
+	if s.db.storage == nil {
+		return first
+	}
+	for _, fid := range s.fids {
+		if err := s.db.storage.Unpin(fid); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,155 @@
+package tiny_bitcask
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/storage"
+)
+
+func TestSnapshot_FrozenView(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	require.NoError(t, db.Set([]byte("b"), []byte("2")))
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+
+	require.NoError(t, db.Set([]byte("a"), []byte("changed")))
+	require.NoError(t, db.Delete([]byte("b")))
+	require.NoError(t, db.Set([]byte("c"), []byte("3")))
+
+	got, err := snap.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(got))
+	got, err = snap.Get([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(got))
+	_, err = snap.Get([]byte("c"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+
+	var kvs []string
+	for k, v := range snap.All() {
+		kvs = append(kvs, string(k)+"="+string(v))
+	}
+	assert.Equal(t, []string{"a=1", "b=2"}, kvs)
+
+	got, err = db.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "changed", string(got))
+
+	require.NoError(t, snap.Release())
+	_, err = snap.Get([]byte("a"))
+	assert.ErrorIs(t, err, SnapshotReleasedErr)
+	assert.NoError(t, snap.Release())
+}
+
+// TestSnapshot_PinsSegmentsAcrossMerge checks merge can run while a snapshot
+// still reads the segments it removes, which are deleted on Release.
+func TestSnapshot_PinsSegmentsAcrossMerge(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("old"), []byte("first")))
+	for i := 0; i < 800; i++ {
+		require.NoError(t, db.Set([]byte("busy"), []byte(fmt.Sprintf("v_%d", i))))
+	}
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+	require.NoError(t, db.Set([]byte("old"), []byte("second")))
+	require.NoError(t, db.Merge())
+
+	obsolete := filepath.Join(db.opt.Dir, "1"+storage.FileSuffix+storage.ObsoleteSuffix)
+	_, err = os.Stat(obsolete)
+	require.NoError(t, err, "pinned segment is retired, not deleted")
+
+	got, err := snap.Get([]byte("old"))
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(got))
+	got, err = db.Get([]byte("old"))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(got))
+
+	require.NoError(t, snap.Release())
+	_, err = os.Stat(obsolete)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestSnapshot_GetRacesRelease reads a retired segment through the snapshot
+// while Release runs: every read must see the snapshot value or
+// SnapshotReleasedErr, never a deleted file. Run with -race.
+func TestSnapshot_GetRacesRelease(t *testing.T) {
+	for round := 0; round < 10; round++ {
+		db := newTestDB(t, func(o *Options) {
+			o.SegmentSize = 4 * storage.KB
+		})
+		require.NoError(t, db.Set([]byte("old"), []byte("first")))
+		for i := 0; i < 400; i++ {
+			require.NoError(t, db.Set([]byte("busy"), []byte(fmt.Sprintf("v_%d", i))))
+		}
+		snap, err := db.Snapshot()
+		require.NoError(t, err)
+		require.NoError(t, db.Set([]byte("old"), []byte("second")))
+		require.NoError(t, db.Merge())
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					got, err := snap.Get([]byte("old"))
+					if errors.Is(err, SnapshotReleasedErr) {
+						return
+					}
+					if !assert.NoError(t, err) {
+						return
+					}
+					assert.Equal(t, "first", string(got))
+				}
+			}()
+		}
+		require.NoError(t, snap.Release())
+		wg.Wait()
+		require.NoError(t, db.Close())
+	}
+}
+
+// TestSnapshot_ObsoleteSegmentIgnoredOnReopen checks a segment retired while
+// pinned (left behind by a crash) is neither replayed nor kept on reopen.
+func TestSnapshot_ObsoleteSegmentIgnoredOnReopen(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "snapreopen")
+	opt := *DefaultOptions
+	opt.Dir = dataDir
+	opt.SegmentSize = 4 * storage.KB
+
+	db1, err := NewDB(&opt)
+	require.NoError(t, err)
+	require.NoError(t, db1.Set([]byte("ghost"), []byte("x")))
+	for i := 0; i < 800; i++ {
+		require.NoError(t, db1.Set([]byte("busy"), []byte(fmt.Sprintf("v_%d", i))))
+	}
+	require.NoError(t, db1.Close())
+
+	seg1 := storage.DataFilePath(dataDir, 1)
+	require.NoError(t, os.Rename(seg1, seg1+storage.ObsoleteSuffix))
+	storage.RemoveHintFile(dataDir, 1)
+
+	db2, err := NewDB(&opt)
+	require.NoError(t, err)
+	defer db2.Close()
+	_, err = db2.Get([]byte("ghost"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+	_, err = os.Stat(seg1 + storage.ObsoleteSuffix)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,54 @@
+package tiny_bitcask
+
+import (
+	"time"
+)
+
+// KeyInfo is the keydir metadata of a key's live record.
+type KeyInfo struct {
+	Timestamp time.Time // when the record was written (second precision)
+	KeySize   int
+	ValueSize int
+	Fid       int       // segment holding the record
+	Offset    int64     // record start offset within the segment
+	ExpiresAt time.Time // zero when the key has no TTL
+	Seq       uint64    // global sequence number of the record's write
+	Version   Version
+}
+
+// Has reports whether key exists, answered from the keydir without disk I/O.
+func (db *DB) Has(key []byte) bool {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	return db.find(string(key)) != nil
+}
+
+// LastSeq returns the sequence number of the latest write, 0 for an empty DB.
+func (db *DB) LastSeq() uint64 {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	return db.storage.Seq()
+}
+
+// Stat returns key's record metadata from the keydir without reading the value.
+func (db *DB) Stat(key []byte) (KeyInfo, error) {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	dp := db.find(string(key))
+	if dp == nil {
+		return KeyInfo{}, KeyNotFoundErr
+	}
+	info := KeyInfo{
+		Timestamp: time.Unix(int64(dp.Timestamp), 0),
+		KeySize:   dp.KeySize,
+		ValueSize: dp.ValueSize,
+		Fid:       dp.Fid,
+		Offset:    dp.Off,
+		Seq:       dp.Seq,
+		Version:   versionOf(dp),
+	}
+	if dp.Expiry != 0 {
+		info.ExpiresAt = time.Unix(0, int64(dp.Expiry))
+	}
+	return info, nil
+}
@@ -0,0 +1,43 @@
+package tiny_bitcask
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tiny-bitcask/storage"
+)
+
+func TestStat_HasAndKeyInfo(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	before := time.Now().Add(-time.Second)
+	require.NoError(t, db.Set([]byte("a"), []byte("hello")))
+	require.NoError(t, db.SetWithTTL([]byte("b"), []byte("x"), time.Hour))
+
+	assert.True(t, db.Has([]byte("a")))
+	assert.False(t, db.Has([]byte("missing")))
+
+	info, err := db.Stat([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, info.KeySize)
+	assert.Equal(t, 5, info.ValueSize)
+	assert.Equal(t, 1, info.Fid)
+	assert.Equal(t, int64(storage.SegmentHeaderSize), info.Offset)
+	assert.True(t, info.ExpiresAt.IsZero())
+	assert.False(t, info.Timestamp.Before(before.Truncate(time.Second)))
+	_, v, err := db.GetWithVersion([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, v, info.Version)
+
+	info, err = db.Stat([]byte("b"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), info.ExpiresAt, time.Minute)
+
+	require.NoError(t, db.Delete([]byte("a")))
+	assert.False(t, db.Has([]byte("a")))
+	_, err = db.Stat([]byte("a"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+}
@@ -0,0 +1,116 @@
+package tiny_bitcask
+
+import (
+	"sync/atomic"
+	"time"
+
+	"tiny-bitcask/index"
+)
+
+// Stats is a point-in-time summary of the database. Counters are cumulative
+// since NewDB and are not persisted.
+type Stats struct {
+	KeyCount    int   // live keys: keydir entries whose TTL has not passed
+	KeydirBytes int64 // estimated heap bytes held by the keydir and retained history
+	Expired     int   // keydir entries past their TTL that the sweeper has not dropped yet
+
+	Segments       []SegmentStats // open segments in fid order, active last
+	TotalBytes     int64          // sum of segment sizes
+	LiveBytes      int64          // bytes of records the keydir points at
+	HintedSegments int            // sealed segments that have a hint file
+	Tombstones     int            // point and range tombstones across all segments
+	OpenFiles      int            // file descriptors held: segments, lock file, on-disk keydir
+
+	Gets        uint64
+	Sets        uint64
+	Deletes     uint64
+	CRCFailures uint64
+	Rotations   uint64
+	Merges      uint64
+
+	LastMergeDuration    time.Duration
+	LastRecoveryDuration time.Duration
+}
+
+// SegmentStats describes one segment. Size-LiveBytes is what merge can reclaim.
+type SegmentStats struct {
+	Fid        int
+	Size       int64
+	LiveBytes  int64
+	Active     bool
+	HasHint    bool
+	Tombstones int
+}
+
+// dbCounters are the DB-level cumulative counters reported by Stats.
+type dbCounters struct {
+	gets, sets, deletes, merges atomic.Uint64
+	lastMerge, lastRecovery     atomic.Int64 // nanoseconds
+}
+
+// Stats returns current statistics under the read lock. Per-segment live
+// bytes come from a walk of the keydir, so the call is O(keys); the first call
+// also counts each segment's tombstones, from its hint file where there is one.
+func (db *DB) Stats() Stats {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	st := Stats{
+		KeyCount:             db.kd.Len(),
+		KeydirBytes:          db.keydirBytes(),
+		Gets:                 db.counters.gets.Load(),
+		Sets:                 db.counters.sets.Load(),
+		Deletes:              db.counters.deletes.Load(),
+		Merges:               db.counters.merges.Load(),
+		LastMergeDuration:    time.Duration(db.counters.lastMerge.Load()),
+		LastRecoveryDuration: time.Duration(db.counters.lastRecovery.Load()),
+	}
+	if db.lockFile != nil {
+		st.OpenFiles++
+	}
+	if db.opt.KeydirOnDisk {
+		st.OpenFiles += 2 // keydir.idx and keydir.keys
+	}
+	if db.storage == nil {
+		return st
+	}
+	c := db.storage.Counters()
+	st.CRCFailures, st.Rotations = c.CRCFailures, c.Rotations
+	st.OpenFiles += db.storage.OpenFiles()
+
+	// An expired record is dead weight like an overwritten one: reads miss it
+	// and merge does not copy it.
+	now := time.Now().UnixNano()
+	live := map[int]int64{}
+	db.kd.Range(func(_ string, dp *index.DataPosition) bool {
+		if dp.Expired(now) {
+			st.Expired++
+			return true
+		}
+		live[dp.Fid] += db.storage.RecordLen(dp.Fid, dp.KeySize, dp.ValueSize)
+		return true
+	})
+	st.KeyCount -= st.Expired
+
+	segs, err := db.storage.Segments()
+	if err != nil {
+		return st
+	}
+	st.Segments = make([]SegmentStats, len(segs))
+	for i, s := range segs {
+		st.Segments[i] = SegmentStats{
+			Fid:        s.Fid,
+			Size:       s.Size,
+			LiveBytes:  live[s.Fid],
+			Active:     s.Active,
+			HasHint:    s.HasHint,
+			Tombstones: s.Tombstones,
+		}
+		st.TotalBytes += s.Size
+		st.Tombstones += s.Tombstones
+		st.LiveBytes += live[s.Fid]
+		if s.HasHint {
+			st.HintedSegments++
+		}
+	}
+	return st
+}
@@ -0,0 +1,176 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+	"tiny-bitcask/storage"
+)
+
+func TestDB_Stats_KeydirBytes(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	require.NoError(t, db.Set([]byte("bb"), []byte("2")))
+	require.NoError(t, db.Set([]byte("a"), []byte("3")))
+
+	st := db.Stats()
+	assert.Equal(t, 2, st.KeyCount)
+	assert.Equal(t, index.EntryBytes("a")+index.EntryBytes("bb"), st.KeydirBytes)
+
+	require.NoError(t, db.Delete([]byte("a")))
+	assert.Equal(t, index.EntryBytes("bb"), db.Stats().KeydirBytes)
+}
+
+func TestDB_MaxKeydirBytes(t *testing.T) {
+	limit := 3 * index.EntryBytes("k_0")
+	db := newTestDB(t, func(o *Options) {
+		o.MaxKeydirBytes = limit
+	})
+	defer db.Close()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k_%d", i)), []byte("v")))
+	}
+
+	assert.ErrorIs(t, db.Set([]byte("k_3"), []byte("v")), KeydirFullErr)
+	assert.NoError(t, db.Set([]byte("k_0"), []byte("overwrite")), "overwrites never grow the keydir")
+	_, err := db.Get([]byte("k_3"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+
+	require.NoError(t, db.Delete([]byte("k_1")))
+	assert.NoError(t, db.Set([]byte("k_3"), []byte("v")), "deletes free room for new keys")
+	assert.LessOrEqual(t, db.Stats().KeydirBytes, limit)
+}
+
+func TestDB_Stats_Tombstones(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	for i := 0; i < 40; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k%02d", i)), make([]byte, 200)))
+	}
+	require.NoError(t, db.Delete([]byte("k00")))
+	require.NoError(t, db.DeletePrefix([]byte("k1")))
+	st := db.Stats()
+	require.Greater(t, len(st.Segments), 1)
+	assert.Equal(t, 2, st.Tombstones)
+
+	// Counted from hints and scans after a reopen, then kept up to date.
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	db, err := NewDB(&Options{Dir: dir, SegmentSize: 4 * storage.KB, VerifyCRC: true})
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, 2, db.Stats().Tombstones)
+	b := NewWriteBatch()
+	b.Delete([]byte("k20"))
+	b.Delete([]byte("k21"))
+	require.NoError(t, db.Write(b))
+	require.NoError(t, db.Delete([]byte("k22")))
+	st = db.Stats()
+	assert.Equal(t, 5, st.Tombstones)
+	var sum int
+	for _, s := range st.Segments {
+		sum += s.Tombstones
+	}
+	assert.Equal(t, st.Tombstones, sum)
+	assert.Equal(t, 40-1-10-3, st.KeyCount)
+}
+
+func TestDB_KeydirBytes_History(t *testing.T) {
+	entry := index.EntryBytes("k")
+	db := newTestDB(t, func(o *Options) {
+		o.RetainVersions = VersionRetention{Count: 2}
+		o.MaxKeydirBytes = entry + historyKeyBytes("k") + 2*versionBytes
+	})
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("k"), []byte("v1")))
+	assert.Equal(t, entry, db.Stats().KeydirBytes)
+	require.NoError(t, db.Set([]byte("k"), []byte("v2")))
+	assert.Equal(t, entry+historyKeyBytes("k")+versionBytes, db.Stats().KeydirBytes)
+	require.NoError(t, db.Set([]byte("k"), []byte("v3")))
+	full := entry + historyKeyBytes("k") + 2*versionBytes
+	assert.Equal(t, full, db.Stats().KeydirBytes)
+
+	require.NoError(t, db.Set([]byte("k"), []byte("v4")), "at the count cap an overwrite drops a version")
+	assert.Equal(t, full, db.Stats().KeydirBytes)
+	assert.ErrorIs(t, db.Set([]byte("n"), []byte("v")), KeydirFullErr)
+	b := NewWriteBatch()
+	b.Set([]byte("n"), []byte("v"))
+	assert.ErrorIs(t, db.Write(b), KeydirFullErr)
+
+	require.NoError(t, db.Delete([]byte("k")), "deletes are never refused")
+	assert.LessOrEqual(t, db.Stats().KeydirBytes, full)
+}
+
+func TestDB_MaxKeydirBytes_HistoryOverwrite(t *testing.T) {
+	entry := index.EntryBytes("k")
+	db := newTestDB(t, func(o *Options) {
+		o.RetainVersions = VersionRetention{Age: time.Hour}
+		o.MaxKeydirBytes = entry + historyKeyBytes("k") + versionBytes
+	})
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("k"), []byte("v1")))
+	require.NoError(t, db.Set([]byte("k"), []byte("v2")))
+	assert.ErrorIs(t, db.Set([]byte("k"), []byte("v3")), KeydirFullErr, "a retained version counts toward the limit")
+	v, err := db.Get([]byte("k"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(v))
+}
+
+func TestDB_Stats_SegmentsAndCounters(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	val := make([]byte, 200)
+	for i := 0; i < 60; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k%02d", i%20)), val))
+	}
+	require.NoError(t, db.Delete([]byte("k00")))
+	_, err := db.Get([]byte("k01"))
+	require.NoError(t, err)
+	_, err = db.Get([]byte("k00"))
+	require.ErrorIs(t, err, KeyNotFoundErr)
+
+	st := db.Stats()
+	assert.Equal(t, 19, st.KeyCount)
+	assert.Equal(t, uint64(60), st.Sets)
+	assert.Equal(t, uint64(1), st.Deletes)
+	assert.Equal(t, uint64(2), st.Gets)
+	require.Greater(t, st.Rotations, uint64(1))
+	require.Len(t, st.Segments, int(st.Rotations)+1)
+	assert.True(t, st.Segments[len(st.Segments)-1].Active)
+	assert.Equal(t, len(st.Segments)-1, st.HintedSegments)
+	// Every segment plus the lock file; rotation must not leak descriptors.
+	assert.Equal(t, len(st.Segments)+1, st.OpenFiles)
+
+	var total, live int64
+	for _, s := range st.Segments {
+		assert.LessOrEqual(t, s.LiveBytes, s.Size)
+		total += s.Size
+		live += s.LiveBytes
+	}
+	assert.Equal(t, total, st.TotalBytes)
+	assert.Equal(t, live, st.LiveBytes)
+	assert.Equal(t, int64(19*(entity.MetaSize+3+200)), st.LiveBytes)
+
+	require.NoError(t, db.Merge())
+	st = db.Stats()
+	assert.Equal(t, uint64(1), st.Merges)
+	assert.Greater(t, st.LastMergeDuration, time.Duration(0))
+	assert.Zero(t, st.LastRecoveryDuration)
+
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	db, err = NewDB(&Options{Dir: dir, SegmentSize: 4 * storage.KB, VerifyCRC: true})
+	require.NoError(t, err)
+	defer db.Close()
+	st = db.Stats()
+	assert.Greater(t, st.LastRecoveryDuration, time.Duration(0))
+	assert.Zero(t, st.Sets)
+}
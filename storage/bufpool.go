@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"sync"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+)
+
+// maxPooledBuf keeps a single huge read from pinning its buffer in the pool.
+const maxPooledBuf = 1 * MB
+
+var readBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 4*KB)
+		return &b
+	},
+}
+
+func getReadBuf(n int) *[]byte {
+	bp := readBufPool.Get().(*[]byte)
+	if cap(*bp) < n {
+		*bp = make([]byte, n)
+	}
+	*bp = (*bp)[:n]
+	return bp
+}
+
+func putReadBuf(bp *[]byte) {
+	if cap(*bp) > maxPooledBuf {
+		return
+	}
+	readBufPool.Put(bp)
+}
+
+// ReadValue reads the record at dp into a pooled buffer and returns its value
+// sliced from that buffer, verifying the CRC when enabled. The value is only
+// valid until release is called, after which the buffer is reused.
+func (dfs *DataFiles) ReadValue(dp *index.DataPosition) (value []byte, release func(), err error) {
+	seg, err := dfs.segmentOf(dp.Fid)
+	if err != nil {
+		return nil, nil, err
+	}
+	bp := getReadBuf(int(seg.recordLen(dp.KeySize, dp.ValueSize)))
+	release = func() { putReadBuf(bp) }
+	if err := dfs.readAt(dp.Fid, *bp, dp.Off); err != nil {
+		release()
+		return nil, nil, err
+	}
+	buf := *bp
+	if dfs.verifyCRC && !entity.VerifyRecordCRC(buf) {
+		release()
+		return nil, nil, dfs.noteErr(CrcErr)
+	}
+	return buf[seg.metaSize()+dp.KeySize:], release, nil
+}
+
+func (dfs *DataFiles) readAt(fid int, buf []byte, off int64) error {
+	seg, err := dfs.segmentOf(fid)
+	if err != nil {
+		return err
+	}
+	n, err := seg.fd.ReadAt(buf, off)
+	if n < len(buf) {
+		return ReadMissDataErr
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
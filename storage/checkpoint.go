@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	CheckpointFileName = "keydir.ckpt"
+
+	checkpointMagic     = "TBCK"
+	checkpointVersion   = byte(3)
+	checkpointHeaderLen = 40
+	checkpointRowLen    = 48
+)
+
+var (
+	ErrInvalidCheckpoint = errors.New("storage: invalid or unsupported checkpoint file")
+)
+
+// CheckpointRecord is one keydir row in a checkpoint file.
+type CheckpointRecord struct {
+	Fid       int
+	Off       int64
+	Timestamp uint64
+	KeySize   uint32
+	ValueSize uint32
+	Expiry    uint64
+	Seq       uint64
+	Key       []byte
+}
+
+// Checkpoint is a full keydir image plus the segment position it covers: every
+// record before (Fid, Off) is reflected in Records, later data must be replayed.
+// Seq is the highest sequence number handed out when it was taken.
+type Checkpoint struct {
+	Fid     int
+	Off     int64
+	Seq     uint64
+	Records []CheckpointRecord
+}
+
+// CheckpointPath returns the path to the keydir checkpoint in dir.
+func CheckpointPath(dir string) string {
+	return filepath.Join(dir, CheckpointFileName)
+}
+
+// WriteCheckpoint atomically replaces the checkpoint file in dir (tmp + fsync + rename).
+func WriteCheckpoint(dir string, cp *Checkpoint) error {
+	tmpPath := CheckpointPath(dir) + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := writeCheckpoint(f, cp); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, CheckpointPath(dir)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func writeCheckpoint(f *os.File, cp *Checkpoint) error {
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(f, crc))
+
+	header := make([]byte, checkpointHeaderLen)
+	copy(header[0:4], checkpointMagic)
+	header[4] = checkpointVersion
+	binary.LittleEndian.PutUint64(header[8:16], uint64(cp.Fid))
+	binary.LittleEndian.PutUint64(header[16:24], uint64(cp.Off))
+	binary.LittleEndian.PutUint64(header[24:32], uint64(len(cp.Records)))
+	binary.LittleEndian.PutUint64(header[32:40], cp.Seq)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	row := make([]byte, checkpointRowLen)
+	for _, r := range cp.Records {
+		binary.LittleEndian.PutUint64(row[0:8], uint64(r.Fid))
+		binary.LittleEndian.PutUint64(row[8:16], uint64(r.Off))
+		binary.LittleEndian.PutUint64(row[16:24], r.Timestamp)
+		binary.LittleEndian.PutUint32(row[24:28], r.KeySize)
+		binary.LittleEndian.PutUint32(row[28:32], r.ValueSize)
+		binary.LittleEndian.PutUint64(row[32:40], r.Expiry)
+		binary.LittleEndian.PutUint64(row[40:48], r.Seq)
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+		if _, err := w.Write(r.Key); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(trailer, crc.Sum32())
+	_, err := f.Write(trailer)
+	return err
+}
+
+// ReadCheckpoint loads and CRC-checks the checkpoint in dir. Caller must validate
+// it against the segments on disk.
+func ReadCheckpoint(dir string) (*Checkpoint, error) {
+	buf, err := os.ReadFile(CheckpointPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < checkpointHeaderLen+4 {
+		return nil, ErrInvalidCheckpoint
+	}
+	body, trailer := buf[:len(buf)-4], buf[len(buf)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(trailer) {
+		return nil, ErrInvalidCheckpoint
+	}
+	if string(body[0:4]) != checkpointMagic || body[4] != checkpointVersion {
+		return nil, ErrInvalidCheckpoint
+	}
+
+	cp := &Checkpoint{
+		Fid: int(binary.LittleEndian.Uint64(body[8:16])),
+		Off: int64(binary.LittleEndian.Uint64(body[16:24])),
+		Seq: binary.LittleEndian.Uint64(body[32:40]),
+	}
+	n := binary.LittleEndian.Uint64(body[24:32])
+	rest := body[checkpointHeaderLen:]
+	if n > uint64(len(rest)/checkpointRowLen) {
+		return nil, ErrInvalidCheckpoint
+	}
+	cp.Records = make([]CheckpointRecord, 0, n)
+	for i := uint64(0); i < n; i++ {
+		if len(rest) < checkpointRowLen {
+			return nil, ErrInvalidCheckpoint
+		}
+		r := CheckpointRecord{
+			Fid:       int(binary.LittleEndian.Uint64(rest[0:8])),
+			Off:       int64(binary.LittleEndian.Uint64(rest[8:16])),
+			Timestamp: binary.LittleEndian.Uint64(rest[16:24]),
+			KeySize:   binary.LittleEndian.Uint32(rest[24:28]),
+			ValueSize: binary.LittleEndian.Uint32(rest[28:32]),
+			Expiry:    binary.LittleEndian.Uint64(rest[32:40]),
+			Seq:       binary.LittleEndian.Uint64(rest[40:48]),
+		}
+		rest = rest[checkpointRowLen:]
+		if uint64(len(rest)) < uint64(r.KeySize) {
+			return nil, ErrInvalidCheckpoint
+		}
+		r.Key = rest[:r.KeySize:r.KeySize]
+		rest = rest[r.KeySize:]
+		cp.Records = append(cp.Records, r)
+	}
+	if len(rest) != 0 {
+		return nil, ErrInvalidCheckpoint
+	}
+	return cp, nil
+}
+
+// RemoveCheckpoint deletes the checkpoint in dir if it exists.
+func RemoveCheckpoint(dir string) error {
+	err := os.Remove(CheckpointPath(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
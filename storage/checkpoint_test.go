@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint_WriteReadRoundtrip(t *testing.T) {
+	tests := []struct {
+		name string
+		cp   *Checkpoint
+	}{
+		{
+			name: "empty_keydir",
+			cp:   &Checkpoint{Fid: 1, Off: 0},
+		},
+		{
+			name: "two_records",
+			cp: &Checkpoint{
+				Fid: 3,
+				Off: 128,
+				Seq: 12,
+				Records: []CheckpointRecord{
+					{Fid: 1, Off: 0, Timestamp: 7, KeySize: 1, ValueSize: 2, Seq: 4, Key: []byte("a")},
+					{Fid: 3, Off: 64, Timestamp: 9, KeySize: 3, ValueSize: 0, Seq: 12, Key: []byte("bcd")},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, WriteCheckpoint(dir, tt.cp))
+
+			got, err := ReadCheckpoint(dir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.cp.Fid, got.Fid)
+			assert.Equal(t, tt.cp.Off, got.Off)
+			assert.Equal(t, tt.cp.Seq, got.Seq)
+			require.Len(t, got.Records, len(tt.cp.Records))
+			for i, r := range tt.cp.Records {
+				assert.Equal(t, r, got.Records[i])
+			}
+		})
+	}
+}
+
+func TestCheckpoint_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+	}{
+		{
+			name:   "truncated",
+			mutate: func(b []byte) []byte { return b[:len(b)-3] },
+		},
+		{
+			name: "flipped_key_byte",
+			mutate: func(b []byte) []byte {
+				b[checkpointHeaderLen+checkpointRowLen] ^= 0xFF
+				return b
+			},
+		},
+		{
+			name:   "too_short",
+			mutate: func([]byte) []byte { return []byte("TBCK") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cp := &Checkpoint{
+				Fid:     1,
+				Off:     40,
+				Records: []CheckpointRecord{{Fid: 1, KeySize: 1, ValueSize: 1, Key: []byte("k")}},
+			}
+			require.NoError(t, WriteCheckpoint(dir, cp))
+			b, err := os.ReadFile(CheckpointPath(dir))
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(CheckpointPath(dir), tt.mutate(b), 0o644))
+
+			_, err = ReadCheckpoint(dir)
+			assert.ErrorIs(t, err, ErrInvalidCheckpoint)
+		})
+	}
+}
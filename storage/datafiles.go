@@ -6,9 +6,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"tiny-bitcask/entity"
 	"tiny-bitcask/index"
 )
@@ -22,11 +25,12 @@ var (
 )
 
 const (
-	FileSuffix = ".dat"
-	B          = 1
-	KB         = 1024 * B
-	MB         = 1024 * KB
-	GB         = 1024 * MB
+	FileSuffix     = ".dat"
+	ObsoleteSuffix = ".obsolete"
+	B              = 1
+	KB             = 1024 * B
+	MB             = 1024 * KB
+	GB             = 1024 * MB
 )
 
 type oldFiles map[int]*OldFile
@@ -43,20 +47,54 @@ type DataFiles struct {
 	olds        map[int]*OldFile
 	verifyCRC   bool
 	readOnly    bool
+
+	pinMu   sync.Mutex
+	pins    map[int]int
+	retired map[int]bool // merged away while pinned; file renamed to fid.dat.obsolete
+
+	tombs tombstoneCounts
+
+	seq         atomic.Uint64 // last sequence number handed out
+	rotations   atomic.Uint64
+	crcFailures atomic.Uint64
+}
+
+// Counters are cumulative DataFiles events since open.
+type Counters struct {
+	Rotations   uint64
+	CRCFailures uint64
+}
+
+// SegmentInfo describes one segment file.
+type SegmentInfo struct {
+	Fid        int
+	Size       int64
+	Active     bool
+	HasHint    bool
+	Tombstones int // committed point and range tombstones
 }
 
 func (dfs *DataFiles) GetOldFiles() []int {
 	return dfs.oIds
 }
 
+// ActiveFid returns the id of the segment currently accepting writes.
+func (dfs *DataFiles) ActiveFid() int {
+	return dfs.active.fid
+}
+
+// ActiveOffset returns the append offset (current size) of the active segment.
+func (dfs *DataFiles) ActiveOffset() int64 {
+	return dfs.active.off
+}
+
 func (dfs *DataFiles) RemoveReader(fid int) error {
 	delete(dfs.olds, fid)
 	return nil
 }
 
 func (dfs *DataFiles) AddReader(fid int) error {
-	path := getFilePath(dfs.dir, fid)
-	reader, err := NewOldFile(path, dfs.verifyCRC)
+	reader, err := NewOldFile(dfs.dir, fid, dfs.verifyCRC)
 	if err != nil {
 		return err
 	}
@@ -64,7 +102,9 @@ func (dfs *DataFiles) AddReader(fid int) error {
 	return nil
 }
 
-// NewDataFileWithFiles create a DataFiles with existing dir
+// NewDataFileWithFiles create a DataFiles with existing dir. Opened for
+// writing, a legacy active segment is sealed at once, so new records always go
+// to a segment in the current format.
 func NewDataFileWithFiles(dir string, segmentSize int64, verifyCRC bool, readOnly bool) (dfs *DataFiles, err error) {
 	dfs = &DataFiles{
 		dir:         dir,
@@ -72,6 +112,8 @@ func NewDataFileWithFiles(dir string, segmentSize int64, verifyCRC bool, readOnl
 		segmentSize: segmentSize,
 		verifyCRC:   verifyCRC,
 		readOnly:    readOnly,
+		pins:        map[int]int{},
+		retired:     map[int]bool{},
 	}
 
 	fids, err := getFids(dir)
@@ -90,18 +132,19 @@ func NewDataFileWithFiles(dir string, segmentSize int64, verifyCRC bool, readOnl
 		dfs.oIds = make([]int, len(fids)-1)
 		copy(dfs.oIds, fids[:len(fids)-1])
 	}
-	if len(fids) == 1 {
-		return dfs, nil
-	}
 	oldFids := fids[:len(fids)-1]
 	for _, fid := range oldFids {
-		path := getFilePath(dir, fid)
-		reader, err := NewOldFile(path, verifyCRC)
+		reader, err := NewOldFile(dir, fid, verifyCRC)
 		if err != nil {
 			return nil, err
 		}
 		dfs.olds[fid] = reader
 	}
+	if !readOnly && dfs.active.legacy {
+		if err := dfs.rotate(); err != nil {
+			return nil, err
+		}
+	}
 
 	return dfs, nil
 }
@@ -124,18 +167,21 @@ func NewDataFiles(path string, segmentSize int64, verifyCRC bool) (dfs *DataFile
 		segmentSize: segmentSize,
 		verifyCRC:   verifyCRC,
 		readOnly:    false,
+		pins:        map[int]int{},
+		retired:     map[int]bool{},
 	}
 	return dfs, nil
 }
 
 func (dfs *DataFiles) rotate() error {
 	aFid := dfs.active.fid
-	path := getFilePath(dfs.dir, aFid)
-	fd, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
-	if err != nil {
+	// Sealed segments are durable, so hints and checkpoints never cover bytes
+	// a power loss could take back.
+	if err := dfs.active.fd.Sync(); err != nil {
 		return err
 	}
-	r := &OldFile{fd: fd, verifyCRC: dfs.verifyCRC}
+	// The sealed segment keeps the active file's descriptor for reads.
+	r := &OldFile{segment: dfs.active.segment}
 	dfs.olds[dfs.active.fid] = r
 	dfs.oIds = append(dfs.oIds, aFid)
 
@@ -144,22 +190,110 @@ func (dfs *DataFiles) rotate() error {
 		return err
 	}
 	dfs.active = af
-	if err := WriteHintFileForDataFile(dfs.dir, aFid, dfs.verifyCRC); err != nil {
+	dfs.rotations.Add(1)
+	if err := WriteHintFileForDataFile(dfs.dir, aFid, dfs.seq.Load(), dfs.verifyCRC); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (dfs *DataFiles) ReadEntry(index *index.DataPosition) (e *entity.Entry, err error) {
-	dataSize := entity.MetaSize + index.KeySize + index.ValueSize
-	if index.Fid == dfs.active.fid {
-		return dfs.active.ReadEntity(index.Off, dataSize)
+	seg, err := dfs.segmentOf(index.Fid)
+	if err != nil {
+		return nil, err
 	}
-	of, exist := dfs.olds[index.Fid]
+	e, err = seg.ReadEntity(index.Off, int(seg.recordLen(index.KeySize, index.ValueSize)))
+	return e, dfs.noteErr(err)
+}
+
+// segmentOf returns the open segment fid, active or sealed.
+func (dfs *DataFiles) segmentOf(fid int) (*segment, error) {
+	if fid == dfs.active.fid {
+		return &dfs.active.segment, nil
+	}
+	of, exist := dfs.olds[fid]
 	if !exist {
 		return nil, MissOldFileErr
 	}
-	return of.ReadEntity(index.Off, dataSize)
+	return &of.segment, nil
+}
+
+// RecordLen returns the length in segment fid of a record with the given key
+// and value sizes; the segment's format fixes its meta length.
+func (dfs *DataFiles) RecordLen(fid int, keySize, valueSize int) int64 {
+	seg, err := dfs.segmentOf(fid)
+	if err != nil {
+		return int64(entity.MetaSize + keySize + valueSize)
+	}
+	return seg.recordLen(keySize, valueSize)
+}
+
+// noteErr counts CRC failures on the way out of a read.
+func (dfs *DataFiles) noteErr(err error) error {
+	if err == CrcErr {
+		dfs.crcFailures.Add(1)
+	}
+	return err
+}
+
+// Counters returns cumulative rotation and CRC failure counts.
+func (dfs *DataFiles) Counters() Counters {
+	return Counters{
+		Rotations:   dfs.rotations.Load(),
+		CRCFailures: dfs.crcFailures.Load(),
+	}
+}
+
+// Segments lists every open segment in fid order with its size, whether a
+// hint file covers it and its tombstone count (see Tombstones). Retired
+// (pinned, merged away) segments are not listed.
+func (dfs *DataFiles) Segments() ([]SegmentInfo, error) {
+	out := make([]SegmentInfo, 0, len(dfs.oIds)+1)
+	fids := append([]int(nil), dfs.oIds...)
+	sort.Ints(fids)
+	for _, fid := range fids {
+		st, err := dfs.olds[fid].fd.Stat()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, SegmentInfo{Fid: fid, Size: st.Size(), HasHint: HintFileExists(dfs.dir, fid)})
+	}
+	out = append(out, SegmentInfo{Fid: dfs.active.fid, Size: dfs.active.off, Active: true})
+	for i := range out {
+		n, err := dfs.Tombstones(out[i].Fid)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Tombstones = n
+	}
+	return out, nil
+}
+
+// OpenFiles returns the number of segment file descriptors held, including
+// retired segments kept open for pins.
+func (dfs *DataFiles) OpenFiles() int {
+	return 1 + len(dfs.olds)
+}
+
+// ReadRange reads length raw bytes at off in segment fid, e.g. a run of
+// adjacent records to be split with DecodeEntry.
+func (dfs *DataFiles) ReadRange(fid int, off int64, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	if err := dfs.readAt(fid, buf, off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// DecodeEntry decodes the record read from offset off of segment fid at the
+// start of buf, verifying its CRC when the DataFiles was opened with verifyCRC.
+func (dfs *DataFiles) DecodeEntry(fid int, off int64, buf []byte) (*entity.Entry, error) {
+	seg, err := dfs.segmentOf(fid)
+	if err != nil {
+		return nil, err
+	}
+	e, err := seg.decode(buf, off)
+	return e, dfs.noteErr(err)
 }
 
 // Sync flushes the active segment to stable storage.
@@ -182,6 +316,14 @@ func (dfs *DataFiles) Close() error {
 			}
 		}
 	}
+	dfs.pinMu.Lock()
+	for fid := range dfs.retired {
+		if err := os.Remove(getFilePath(dfs.dir, fid) + ObsoleteSuffix); err != nil && first == nil {
+			first = err
+		}
+	}
+	dfs.retired = map[int]bool{}
+	dfs.pinMu.Unlock()
 	dfs.olds = nil
 	dfs.active = nil
 	return first
@@ -191,19 +333,35 @@ func (dfs *DataFiles) GetOldFile(fid int) *OldFile {
 	return dfs.olds[fid]
 }
 
+// RemoveFile deletes a merged segment and its hint. A pinned segment is instead
+// renamed to fid.dat.obsolete, so recovery no longer sees it, and stays readable
+// until the last Unpin.
 func (dfs *DataFiles) RemoveFile(fid int) error {
-	of := dfs.olds[fid]
-	err := of.fd.Close()
-	if err != nil {
-		return err
-	}
+	dfs.pinMu.Lock()
+	pinned := dfs.pins[fid] > 0
+	dfs.pinMu.Unlock()
 	path := getFilePath(dfs.dir, fid)
-	err = os.Remove(path)
-	if err != nil {
-		return err
+	if pinned {
+		if err := os.Rename(path, path+ObsoleteSuffix); err != nil {
+			return err
+		}
+		dfs.pinMu.Lock()
+		dfs.retired[fid] = true
+		dfs.pinMu.Unlock()
+	} else {
+		of := dfs.olds[fid]
+		err := of.fd.Close()
+		if err != nil {
+			return err
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		delete(dfs.olds, fid)
 	}
 	RemoveHintFile(dfs.dir, fid)
-	delete(dfs.olds, fid)
+	dfs.tombs.forget(fid)
 	for i, id := range dfs.oIds {
 		if id == fid {
 			dfs.oIds = append(dfs.oIds[:i], dfs.oIds[i+1:]...)
@@ -213,7 +371,82 @@ func (dfs *DataFiles) RemoveFile(fid int) error {
 	return nil
 }
 
-func (dfs *DataFiles) WriterEntity(e entity.Entity) (h *entity.Hint, err error) {
+// Pin keeps segment fid readable through ReadEntry even if merge removes it.
+// Safe to call concurrently with other Pin calls.
+func (dfs *DataFiles) Pin(fid int) {
+	dfs.pinMu.Lock()
+	defer dfs.pinMu.Unlock()
+	dfs.pins[fid]++
+}
+
+// Unpin drops one Pin; the last Unpin of a retired segment closes and deletes it.
+// Caller must exclude concurrent readers of the DataFiles.
+func (dfs *DataFiles) Unpin(fid int) error {
+	dfs.pinMu.Lock()
+	defer dfs.pinMu.Unlock()
+	dfs.pins[fid]--
+	if dfs.pins[fid] > 0 {
+		return nil
+	}
+	delete(dfs.pins, fid)
+	if !dfs.retired[fid] {
+		return nil
+	}
+	delete(dfs.retired, fid)
+	of := dfs.olds[fid]
+	delete(dfs.olds, fid)
+	if of != nil {
+		if err := of.Close(); err != nil {
+			return err
+		}
+	}
+	return os.Remove(getFilePath(dfs.dir, fid) + ObsoleteSuffix)
+}
+
+// RemoveObsoleteFiles deletes segments left retired by a process that exited
+// while they were pinned.
+func RemoveObsoleteFiles(dir string) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+FileSuffix+ObsoleteSuffix))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Seq returns the last sequence number handed out, 0 before the first write.
+func (dfs *DataFiles) Seq() uint64 {
+	return dfs.seq.Load()
+}
+
+// SetSeq raises the sequence counter to at least n, so the next write gets a
+// higher number; recovery calls it with the highest number found on disk.
+func (dfs *DataFiles) SetSeq(n uint64) {
+	for {
+		cur := dfs.seq.Load()
+		if n <= cur || dfs.seq.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+func (dfs *DataFiles) nextSeq() uint64 {
+	return dfs.seq.Add(1)
+}
+
+// WriterEntity appends e after stamping it with the next sequence number.
+func (dfs *DataFiles) WriterEntity(e *entity.Entry) (h *entity.Hint, err error) {
+	e.Meta.Seq = dfs.nextSeq()
+	return dfs.CopyEntity(e)
+}
+
+// CopyEntity appends e keeping the sequence number it carries, for merge
+// copies of records already written once.
+func (dfs *DataFiles) CopyEntity(e *entity.Entry) (h *entity.Hint, err error) {
 	if dfs.readOnly {
 		return nil, errors.New("storage: read-only database")
 	}
@@ -221,6 +454,9 @@ func (dfs *DataFiles) WriterEntity(e entity.Entity) (h *entity.Hint, err error)
 	if err != nil {
 		return nil, err
 	}
+	if isTombstone(e.Meta.Flag) {
+		dfs.tombs.added(h.Fid, 1)
+	}
 	if dfs.canRotate() {
 		err := dfs.rotate()
 		if err != nil {
@@ -230,17 +466,61 @@ func (dfs *DataFiles) WriterEntity(e entity.Entity) (h *entity.Hint, err error)
 	return h, nil
 }
 
+// WriteBatch appends entries as one atomic batch: a BatchFlag header, the
+// entries, then a BatchCommitFlag record, all in a single write to the active
+// segment. Rotation is deferred until after the commit record, so a batch never
+// spans segments. Each entry gets its own sequence number; the markers get
+// none. The returned hints are in entry order.
+func (dfs *DataFiles) WriteBatch(entries []*entity.Entry) (hs []*entity.Hint, err error) {
+	if dfs.readOnly {
+		return nil, errors.New("storage: read-only database")
+	}
+	for _, e := range entries {
+		e.Meta.Seq = dfs.nextSeq()
+	}
+	hs, err = dfs.active.writeBatch(entries)
+	if err != nil {
+		return nil, err
+	}
+	tombs := 0
+	for _, e := range entries {
+		if isTombstone(e.Meta.Flag) {
+			tombs++
+		}
+	}
+	dfs.tombs.added(dfs.active.fid, tombs)
+	if dfs.canRotate() {
+		if err := dfs.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return hs, nil
+}
+
+// TruncateActive cuts the active segment back to off, dropping a torn tail left
+// by a crash so later appends are not shadowed by it.
+func (dfs *DataFiles) TruncateActive(off int64) error {
+	if off >= dfs.active.off {
+		return nil
+	}
+	if err := dfs.active.fd.Truncate(off); err != nil {
+		return err
+	}
+	dfs.active.off = off
+	return nil
+}
+
 func (dfs *DataFiles) canRotate() bool {
 	return dfs.active.off > dfs.segmentSize
 }
 
 type ActiveFile struct {
-	fid       int
-	fd        *os.File
-	off       int64
-	verifyCRC bool
+	segment
+	off int64
 }
 
+// NewActiveFile opens segment fid for appending, writing the segment header
+// first when the file is new.
 func NewActiveFile(dir string, fid int, readOnly, verifyCRC bool) (af *ActiveFile, err error) {
 	path := getFilePath(dir, fid)
 	flag := os.O_CREATE | os.O_RDWR
@@ -253,13 +533,21 @@ func NewActiveFile(dir string, fid int, readOnly, verifyCRC bool) (af *ActiveFil
 	}
 	fi, err := os.Stat(path)
 	if err != nil {
+		fd.Close()
 		return nil, err
 	}
-	af = &ActiveFile{
-		fd:        fd,
-		off:       fi.Size(),
-		fid:       fid,
-		verifyCRC: verifyCRC,
+	seg, err := openSegment(fid, fd, verifyCRC)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	af = &ActiveFile{segment: seg, off: fi.Size()}
+	if af.off == 0 && !readOnly {
+		if err := writeSegmentHeader(fd); err != nil {
+			fd.Close()
+			return nil, err
+		}
+		af.off = SegmentHeaderSize
 	}
 	return af, nil
 }
@@ -278,79 +566,49 @@ func (af *ActiveFile) WriterEntity(e entity.Entity) (h *entity.Hint, err error)
 	return h, nil
 }
 
-func (af *ActiveFile) ReadEntity(off int64, length int) (e *entity.Entry, err error) {
-	return readEntry(af.fd, off, length, af.verifyCRC)
-}
-
-type OldFile struct {
-	fd        *os.File
-	verifyCRC bool
-}
-
-func NewOldFile(path string, verifyCRC bool) (of *OldFile, err error) {
-	fd, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
+func (af *ActiveFile) writeBatch(entries []*entity.Entry) (hs []*entity.Hint, err error) {
+	begin := entity.NewBatchMarker(entity.BatchFlag, uint32(len(entries)))
+	commit := entity.NewBatchMarker(entity.BatchCommitFlag, uint32(len(entries)))
+	buf := begin.Encode()
+	hs = make([]*entity.Hint, len(entries))
+	off := af.off + begin.Size()
+	for i, e := range entries {
+		buf = append(buf, e.Encode()...)
+		hs[i] = entity.NewHint().WithFid(af.fid).WithOff(off)
+		off += e.Size()
+	}
+	buf = append(buf, commit.Encode()...)
+	n, err := af.fd.WriteAt(buf, af.off)
+	if n < len(buf) {
+		return nil, WriteMissDataErr
+	}
 	if err != nil {
 		return nil, err
 	}
-	of = &OldFile{fd: fd, verifyCRC: verifyCRC}
-	return of, nil
-}
-
-func (of *OldFile) Close() error {
-	return of.fd.Close()
+	af.off += int64(len(buf))
+	return hs, nil
 }
 
-func (of *OldFile) ReadEntity(off int64, length int) (e *entity.Entry, err error) {
-	return readEntry(of.fd, off, length, of.verifyCRC)
+type OldFile struct {
+	segment
 }
 
-func (of *OldFile) ReadEntityWithOutLength(off int64) (e *entity.Entry, err error) {
-	e = entity.NewEntry().WithMeta(entity.NewMeta())
-	metaBuf := make([]byte, entity.MetaSize)
-	n, err := of.fd.ReadAt(metaBuf, off)
+// NewOldFile opens sealed segment fid for reading.
+func NewOldFile(dir string, fid int, verifyCRC bool) (of *OldFile, err error) {
+	fd, err := os.OpenFile(getFilePath(dir, fid), os.O_RDONLY, os.ModePerm)
 	if err != nil {
 		return nil, err
 	}
-	if n < entity.MetaSize {
-		return nil, ReadMissDataErr
-	}
-	payloadOff := off + entity.MetaSize
-	e.DecodeMeta(metaBuf)
-	payloadSize := e.Meta.KeySize + e.Meta.ValueSize
-	payloadBuf := make([]byte, payloadSize)
-	n, err = of.fd.ReadAt(payloadBuf, payloadOff)
+	seg, err := openSegment(fid, fd, verifyCRC)
 	if err != nil {
+		fd.Close()
 		return nil, err
 	}
-	if n < int(payloadSize) {
-		return nil, ReadMissDataErr
-	}
-	if of.verifyCRC {
-		full := append(metaBuf, payloadBuf...)
-		if !entity.VerifyRecordCRC(full) {
-			return nil, CrcErr
-		}
-	}
-	e.DecodePayload(payloadBuf)
-	return e, nil
+	return &OldFile{segment: seg}, nil
 }
 
-func readEntry(fd *os.File, off int64, length int, verifyCRC bool) (e *entity.Entry, err error) {
-	buf := make([]byte, length)
-	n, err := fd.ReadAt(buf, off)
-	if n < length {
-		return nil, ReadMissDataErr
-	}
-	if err != nil {
-		return nil, err
-	}
-	if verifyCRC && !entity.VerifyRecordCRC(buf) {
-		return nil, CrcErr
-	}
-	e = entity.NewEntry().WithMeta(entity.NewMeta())
-	e.DecodeMeta(buf[:entity.MetaSize])
-	e.DecodePayload(buf[entity.MetaSize:])
-	return e, nil
+func (of *OldFile) Close() error {
+	return of.fd.Close()
 }
 
 // ListDataFileIDs returns numeric file IDs for all segment files in dir.
@@ -12,8 +12,9 @@ import (
 
 const (
 	hintMagic     = "TBHK"
-	hintVersion   = byte(1)
-	hintHeaderLen = 8
+	hintVersion   = byte(4)
+	hintHeaderLen = 16
+	hintRowLen    = 41
 )
 
 var (
@@ -27,7 +28,10 @@ type HintRecord struct {
 	ValueSize    uint32
 	RecordOffset int64
 	Flag         uint8
+	Expiry       uint64
+	Seq          uint64
 	Key          []byte
+	End          []byte // range tombstone rows only: exclusive upper bound, empty = unbounded
 }
 
 // HintFilePath returns the path to the hint file for segment fid.
@@ -36,10 +40,12 @@ func HintFilePath(dir string, fid int) string {
 }
 
 // WriteHintFileForDataFile scans a sealed .dat file and writes a companion .hint file
-// (timestamp, sizes, record offset, flag, key only — no values).
-func WriteHintFileForDataFile(dir string, fid int, verifyCRC bool) error {
-	datPath := getFilePath(dir, fid)
-	of, err := NewOldFile(datPath, verifyCRC)
+// (timestamp, sizes, record offset, flag, expiry, seq, key only — no values). Tombstones
+// are kept, a range tombstone followed by its end key, so replay from hints honours them.
+// The header records seq, the highest sequence number handed out when the segment
+// was sealed, which stays recoverable even after merge drops older segments.
+func WriteHintFileForDataFile(dir string, fid int, seq uint64, verifyCRC bool) error {
+	of, err := NewOldFile(dir, fid, verifyCRC)
 	if err != nil {
 		return err
 	}
@@ -54,42 +60,37 @@ func WriteHintFileForDataFile(dir string, fid int, verifyCRC bool) error {
 	header := make([]byte, hintHeaderLen)
 	copy(header[0:4], hintMagic)
 	header[4] = hintVersion
+	binary.LittleEndian.PutUint64(header[8:16], seq)
 	if _, err := f.Write(header); err != nil {
 		f.Close()
 		os.Remove(tmpPath)
 		return err
 	}
 
-	var off int64
-	for {
-		entry, err := of.ReadEntityWithOutLength(off)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			f.Close()
-			os.Remove(tmpPath)
-			return err
+	_, err = of.Scan(0, func(recOff int64, entry *entity.Entry) error {
+		switch entry.Meta.Flag {
+		case entity.VersionFlag, entity.VersionDeleteFlag:
+			return nil
 		}
-		recOff := off
-		off += entry.Size()
-
-		if entry.Meta.Flag == entity.DeleteFlag {
-			continue
-		}
-
-		rec := make([]byte, 25+len(entry.Key))
+		rec := make([]byte, hintRowLen+len(entry.Key), hintRowLen+len(entry.Key)+len(entry.Value))
 		binary.LittleEndian.PutUint64(rec[0:8], entry.Meta.TimeStamp)
 		binary.LittleEndian.PutUint32(rec[8:12], entry.Meta.KeySize)
 		binary.LittleEndian.PutUint32(rec[12:16], entry.Meta.ValueSize)
 		binary.LittleEndian.PutUint64(rec[16:24], uint64(recOff))
 		rec[24] = entry.Meta.Flag
-		copy(rec[25:], entry.Key)
-		if _, err := f.Write(rec); err != nil {
-			f.Close()
-			os.Remove(tmpPath)
-			return err
+		binary.LittleEndian.PutUint64(rec[25:33], entry.Meta.Expiry)
+		binary.LittleEndian.PutUint64(rec[33:41], entry.Meta.Seq)
+		copy(rec[hintRowLen:], entry.Key)
+		if entry.Meta.Flag == entity.RangeDeleteFlag {
+			rec = append(rec, entry.Value...)
 		}
+		_, err := f.Write(rec)
+		return err
+	})
+	if err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
 	}
 
 	if err := f.Sync(); err != nil {
@@ -110,53 +111,64 @@ func WriteHintFileForDataFile(dir string, fid int, verifyCRC bool) error {
 	return nil
 }
 
-// ReadHintFile reads and parses a .hint file. Caller must validate it matches the .dat.
-func ReadHintFile(dir string, fid int) ([]HintRecord, error) {
+// ReadHintFile reads and parses a .hint file, returning its rows and the
+// sequence high-water mark from its header. Caller must validate it matches the .dat.
+func ReadHintFile(dir string, fid int) ([]HintRecord, uint64, error) {
 	p := HintFilePath(dir, fid)
 	f, err := os.Open(p)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer f.Close()
 
 	st, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if st.Size() < int64(hintHeaderLen) {
-		return nil, ErrInvalidHintFile
+		return nil, 0, ErrInvalidHintFile
 	}
 
 	header := make([]byte, hintHeaderLen)
 	if _, err := io.ReadFull(f, header); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if string(header[0:4]) != hintMagic || header[4] != hintVersion {
-		return nil, ErrInvalidHintFile
+		return nil, 0, ErrInvalidHintFile
 	}
+	seq := binary.LittleEndian.Uint64(header[8:16])
 
 	var out []HintRecord
 	for {
-		fixed := make([]byte, 25)
+		fixed := make([]byte, hintRowLen)
 		_, err := io.ReadFull(f, fixed)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			if err == io.ErrUnexpectedEOF {
-				return nil, ErrInvalidHintFile
+				return nil, 0, ErrInvalidHintFile
 			}
-			return nil, err
+			return nil, 0, err
 		}
 		ts := binary.LittleEndian.Uint64(fixed[0:8])
 		ks := binary.LittleEndian.Uint32(fixed[8:12])
 		vs := binary.LittleEndian.Uint32(fixed[12:16])
 		recOff := int64(binary.LittleEndian.Uint64(fixed[16:24]))
 		flag := fixed[24]
+		expiry := binary.LittleEndian.Uint64(fixed[25:33])
+		rseq := binary.LittleEndian.Uint64(fixed[33:41])
 
 		key := make([]byte, ks)
 		if _, err := io.ReadFull(f, key); err != nil {
-			return nil, ErrInvalidHintFile
+			return nil, 0, ErrInvalidHintFile
+		}
+		var end []byte
+		if flag == entity.RangeDeleteFlag {
+			end = make([]byte, vs)
+			if _, err := io.ReadFull(f, end); err != nil {
+				return nil, 0, ErrInvalidHintFile
+			}
 		}
 		out = append(out, HintRecord{
 			Timestamp:    ts,
@@ -164,10 +176,13 @@ func ReadHintFile(dir string, fid int) ([]HintRecord, error) {
 			ValueSize:    vs,
 			RecordOffset: recOff,
 			Flag:         flag,
+			Expiry:       expiry,
+			Seq:          rseq,
 			Key:          key,
+			End:          end,
 		})
 	}
-	return out, nil
+	return out, seq, nil
 }
 
 // HintFileExists reports whether a hint file is present for the segment.
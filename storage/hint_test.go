@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -25,7 +26,7 @@ func TestHintFile_WriteReadRoundtrip(t *testing.T) {
 			value:         []byte("world"),
 			wantKeySize:   5,
 			wantValueSize: 5,
-			wantOffset:    0,
+			wantOffset:    SegmentHeaderSize,
 		},
 		{
 			name:          "empty_value",
@@ -33,7 +34,7 @@ func TestHintFile_WriteReadRoundtrip(t *testing.T) {
 			value:         []byte{},
 			wantKeySize:   1,
 			wantValueSize: 0,
-			wantOffset:    0,
+			wantOffset:    SegmentHeaderSize,
 		},
 	}
 	for _, tt := range tests {
@@ -45,17 +46,19 @@ func TestHintFile_WriteReadRoundtrip(t *testing.T) {
 			require.NoError(t, err)
 
 			e := entity.NewEntryWithData(tt.key, tt.value)
-			buf := e.Encode()
-			_, err = f.WriteAt(buf, 0)
+			e.Meta.WithSeq(7)
+			require.NoError(t, writeSegmentHeader(f))
+			_, err = f.WriteAt(e.Encode(), SegmentHeaderSize)
 			require.NoError(t, err)
 			require.NoError(t, f.Close())
 
-			require.NoError(t, WriteHintFileForDataFile(dir, fid, true))
+			require.NoError(t, WriteHintFileForDataFile(dir, fid, 9, true))
 
 			assert.True(t, HintFileExists(dir, fid))
 
-			recs, err := ReadHintFile(dir, fid)
+			recs, seq, err := ReadHintFile(dir, fid)
 			require.NoError(t, err)
+			assert.Equal(t, uint64(9), seq)
 			require.Len(t, recs, 1)
 			r := recs[0]
 			assert.Equal(t, e.Meta.TimeStamp, r.Timestamp)
@@ -63,24 +66,24 @@ func TestHintFile_WriteReadRoundtrip(t *testing.T) {
 			assert.Equal(t, tt.wantValueSize, r.ValueSize)
 			assert.Equal(t, tt.wantOffset, r.RecordOffset)
 			assert.Equal(t, byte(0), r.Flag)
+			assert.Equal(t, uint64(7), r.Seq)
 			assert.Equal(t, string(tt.key), string(r.Key))
 		})
 	}
 }
 
-func TestHintFile_SkipsTombstone(t *testing.T) {
+func TestHintFile_KeepsTombstone(t *testing.T) {
 	tests := []struct {
-		name    string
-		setup   func(t *testing.T, f *os.File) error
-		wantLen int
-		wantKey string
+		name  string
+		setup func(t *testing.T, f *os.File) error
+		want  []string // key and flag of each row
 	}{
 		{
 			name: "live_then_tombstone",
 			setup: func(t *testing.T, f *os.File) error {
 				t.Helper()
 				live := entity.NewEntryWithData([]byte("k1"), []byte("v1"))
-				off := int64(0)
+				off := int64(SegmentHeaderSize)
 				if _, err := f.WriteAt(live.Encode(), off); err != nil {
 					return err
 				}
@@ -89,19 +92,17 @@ func TestHintFile_SkipsTombstone(t *testing.T) {
 				_, err := f.WriteAt(tomb.Encode(), off)
 				return err
 			},
-			wantLen: 1,
-			wantKey: "k1",
+			want: []string{"k1/0", "k2/1"},
 		},
 		{
 			name: "only_tombstone",
 			setup: func(t *testing.T, f *os.File) error {
 				t.Helper()
 				tomb := entity.NewTombstoneEntry([]byte("k2"))
-				_, err := f.WriteAt(tomb.Encode(), 0)
+				_, err := f.WriteAt(tomb.Encode(), SegmentHeaderSize)
 				return err
 			},
-			wantLen: 0,
-			wantKey: "",
+			want: []string{"k2/1"},
 		},
 	}
 	for _, tt := range tests {
@@ -111,16 +112,18 @@ func TestHintFile_SkipsTombstone(t *testing.T) {
 			datPath := getFilePath(dir, fid)
 			f, err := os.OpenFile(datPath, os.O_CREATE|os.O_RDWR, 0o644)
 			require.NoError(t, err)
+			require.NoError(t, writeSegmentHeader(f))
 			require.NoError(t, tt.setup(t, f))
 			require.NoError(t, f.Close())
 
-			require.NoError(t, WriteHintFileForDataFile(dir, fid, true))
-			recs, err := ReadHintFile(dir, fid)
+			require.NoError(t, WriteHintFileForDataFile(dir, fid, 0, true))
+			recs, _, err := ReadHintFile(dir, fid)
 			require.NoError(t, err)
-			require.Len(t, recs, tt.wantLen)
-			if tt.wantLen > 0 {
-				assert.Equal(t, tt.wantKey, string(recs[0].Key))
+			var got []string
+			for _, r := range recs {
+				got = append(got, fmt.Sprintf("%s/%d", r.Key, r.Flag))
 			}
+			assert.Equal(t, tt.want, got)
 		})
 	}
 }
@@ -144,7 +147,7 @@ func TestHintFile_InvalidHeader(t *testing.T) {
 		{
 			name:    "bad_version",
 			fid:     11,
-			content: append([]byte("TBHK"), 0xFF, 0, 0, 0),
+			content: append([]byte("TBHK"), 0xFF, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0),
 		},
 	}
 	for _, tt := range tests {
@@ -152,7 +155,7 @@ func TestHintFile_InvalidHeader(t *testing.T) {
 			dir := t.TempDir()
 			p := filepath.Join(dir, filepath.Base(HintFilePath(dir, tt.fid)))
 			require.NoError(t, os.WriteFile(p, tt.content, 0o644))
-			_, err := ReadHintFile(dir, tt.fid)
+			_, _, err := ReadHintFile(dir, tt.fid)
 			assert.ErrorIs(t, err, ErrInvalidHintFile)
 		})
 	}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"io"
+
+	"tiny-bitcask/entity"
+)
+
+type scannedEntry struct {
+	off   int64
+	entry *entity.Entry
+}
+
+// Scan reads records from byte offset from (at least the segment's first record)
+// to the end of the segment and calls
+// fn for every visible one: records outside a batch, and records of a batch
+// whose commit marker is present. Batch markers themselves are not passed to
+// fn, and the records of a batch that was never committed (a torn write, or a
+// header followed by anything but its records and commit) are dropped.
+//
+// end is the offset just past the last visible record or commit marker; bytes
+// beyond it are a torn tail that an active segment should truncate. A CRC
+// failure anywhere, the final record included, fails the scan: a sealed
+// segment was synced before it was sealed, so it has no torn tail to forgive.
+func (of *OldFile) Scan(from int64, fn func(off int64, e *entity.Entry) error) (end int64, err error) {
+	return of.scan(from, false, fn)
+}
+
+// ScanActive is Scan for the active segment, where a CRC failure on the final
+// record is a write cut short after its bytes were allocated (WriteStream
+// stores the CRC last) and ends the scan like EOF.
+func (of *OldFile) ScanActive(from int64, fn func(off int64, e *entity.Entry) error) (end int64, err error) {
+	return of.scan(from, true, fn)
+}
+
+// scan is Scan, forgiving a torn final record when tornTail is set.
+func (of *OldFile) scan(from int64, tornTail bool, fn func(off int64, e *entity.Entry) error) (end int64, err error) {
+	var (
+		pending []scannedEntry
+		inBatch bool
+		want    uint32
+	)
+	off := max(from, of.start())
+	end = off
+	for {
+		e, err := of.ReadEntityWithOutLength(off)
+		if err != nil {
+			if err == io.EOF || (tornTail && err == CrcErr && of.isLastRecord(off)) {
+				return end, nil
+			}
+			return end, err
+		}
+		recOff := off
+		off += of.recordLen(int(e.Meta.KeySize), int(e.Meta.ValueSize))
+
+		switch e.Meta.Flag {
+		case entity.BatchFlag:
+			pending, inBatch, want = pending[:0], true, e.BatchCount()
+		case entity.BatchCommitFlag:
+			if inBatch && e.BatchCount() == want && uint32(len(pending)) == want {
+				for _, p := range pending {
+					if err := fn(p.off, p.entry); err != nil {
+						return end, err
+					}
+				}
+				end = off
+			}
+			pending, inBatch = pending[:0], false
+		default:
+			if inBatch && uint32(len(pending)) < want {
+				pending = append(pending, scannedEntry{off: recOff, entry: e})
+				continue
+			}
+			pending, inBatch = pending[:0], false
+			if err := fn(recOff, e); err != nil {
+				return end, err
+			}
+			end = off
+		}
+	}
+}
+
+// isLastRecord reports whether the record at off, by the sizes in its meta,
+// ends exactly at the end of the file.
+func (of *OldFile) isLastRecord(off int64) bool {
+	meta := make([]byte, of.metaSize())
+	if n, _ := of.fd.ReadAt(meta, off); n < len(meta) {
+		return false
+	}
+	e := entity.NewEntry().WithMeta(entity.NewMeta())
+	e.DecodeMeta(meta)
+	st, err := of.fd.Stat()
+	if err != nil {
+		return false
+	}
+	return off+of.recordLen(int(e.Meta.KeySize), int(e.Meta.ValueSize)) == st.Size()
+}
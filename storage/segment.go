@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"tiny-bitcask/entity"
+)
+
+// Every segment written since record meta gained the expiry field starts with
+// an 8-byte header: "TBSG", a version byte and three reserved bytes; records
+// follow it. A segment without the header is legacy: its records carry
+// entity.LegacyMetaSize meta, never expire and have no sequence number. Legacy
+// segments stay readable; a writable open seals a legacy active segment so no
+// new record joins one, and merge rewrites legacy records in the current format.
+const (
+	SegmentHeaderSize = 8
+	segmentMagic      = "TBSG"
+	segmentVersion    = byte(1)
+)
+
+var (
+	SegmentVersionErr = errors.New("storage: unsupported segment version")
+)
+
+// segment is one open segment file and the record format it holds.
+type segment struct {
+	fid       int
+	fd        *os.File
+	legacy    bool
+	verifyCRC bool
+}
+
+// openSegment reads the header of segment fid from fd. An empty file is a new
+// segment whose header has not been written yet.
+func openSegment(fid int, fd *os.File, verifyCRC bool) (segment, error) {
+	s := segment{fid: fid, fd: fd, verifyCRC: verifyCRC}
+	head := make([]byte, SegmentHeaderSize)
+	n, err := fd.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return s, err
+	}
+	switch {
+	case n == 0:
+	case n >= len(segmentMagic) && string(head[:len(segmentMagic)]) == segmentMagic:
+		if n < SegmentHeaderSize || head[4] != segmentVersion {
+			return s, SegmentVersionErr
+		}
+	default:
+		s.legacy = true
+	}
+	return s, nil
+}
+
+func writeSegmentHeader(fd *os.File) error {
+	head := make([]byte, SegmentHeaderSize)
+	copy(head, segmentMagic)
+	head[4] = segmentVersion
+	n, err := fd.WriteAt(head, 0)
+	if n < len(head) {
+		return WriteMissDataErr
+	}
+	return err
+}
+
+// metaSize is the meta length of the segment's records.
+func (s *segment) metaSize() int {
+	if s.legacy {
+		return entity.LegacyMetaSize
+	}
+	return entity.MetaSize
+}
+
+// start is the offset of the segment's first record.
+func (s *segment) start() int64 {
+	if s.legacy {
+		return 0
+	}
+	return SegmentHeaderSize
+}
+
+// recordLen is the length of a record with the given key and value sizes.
+func (s *segment) recordLen(keySize, valueSize int) int64 {
+	return int64(s.metaSize() + keySize + valueSize)
+}
+
+func (s *segment) ReadEntity(off int64, length int) (e *entity.Entry, err error) {
+	buf, err := readRange(s.fd, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return s.decode(buf, off)
+}
+
+// ReadEntityWithOutLength reads the record at off, taking its length from its meta.
+func (s *segment) ReadEntityWithOutLength(off int64) (e *entity.Entry, err error) {
+	metaBuf := make([]byte, s.metaSize())
+	n, err := s.fd.ReadAt(metaBuf, off)
+	if err != nil {
+		return nil, err
+	}
+	if n < len(metaBuf) {
+		return nil, ReadMissDataErr
+	}
+	e = entity.NewEntry().WithMeta(entity.NewMeta())
+	e.DecodeMeta(metaBuf)
+	payloadBuf := make([]byte, e.Meta.KeySize+e.Meta.ValueSize)
+	n, err = s.fd.ReadAt(payloadBuf, off+int64(len(metaBuf)))
+	if err != nil {
+		return nil, err
+	}
+	if n < len(payloadBuf) {
+		return nil, ReadMissDataErr
+	}
+	return s.decode(append(metaBuf, payloadBuf...), off)
+}
+
+// decode decodes the record at off from buf. A legacy record is numbered by
+// legacySeq.
+func (s *segment) decode(buf []byte, off int64) (*entity.Entry, error) {
+	e, err := decodeEntry(buf, s.metaSize(), s.verifyCRC)
+	if err != nil {
+		return nil, err
+	}
+	if s.legacy {
+		e.Meta.Seq = legacySeq(s.fid, off)
+	}
+	return e, nil
+}
+
+// legacySeq numbers a legacy record by its position: stable across opens and
+// merges (merge copies keep it), in write order, and below every number handed
+// out later, since recovery raises the counter past the highest one it reads.
+func legacySeq(fid int, off int64) uint64 {
+	return uint64(fid)<<40 | uint64(off)
+}
+
+func readRange(fd *os.File, off int64, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := fd.ReadAt(buf, off)
+	if n < length {
+		return nil, ReadMissDataErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func decodeEntry(buf []byte, metaSize int, verifyCRC bool) (*entity.Entry, error) {
+	if len(buf) < metaSize {
+		return nil, ReadMissDataErr
+	}
+	if verifyCRC && !entity.VerifyRecordCRC(buf) {
+		return nil, CrcErr
+	}
+	e := entity.NewEntry().WithMeta(entity.NewMeta())
+	e.DecodeMeta(buf[:metaSize])
+	if int(e.Meta.KeySize)+int(e.Meta.ValueSize) > len(buf)-metaSize {
+		return nil, ReadMissDataErr
+	}
+	e.DecodePayload(buf[metaSize:])
+	return e, nil
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+
+	"tiny-bitcask/entity"
+)
+
+const streamChunk = 64 * KB
+
+// WriteStream appends a record whose value is copied from r in chunks, so the
+// value is never held in memory whole. meta must carry the key and value
+// sizes; exactly meta.ValueSize bytes are read from r. The CRC is computed
+// along the way and written last; on any error the partial record is cut off.
+// meta is stamped with the next sequence number.
+func (dfs *DataFiles) WriteStream(meta *entity.Meta, key []byte, r io.Reader) (h *entity.Hint, err error) {
+	if dfs.readOnly {
+		return nil, errors.New("storage: read-only database")
+	}
+	meta.Seq = dfs.nextSeq()
+	h, err = dfs.active.writeStream(meta, key, r)
+	if err != nil {
+		return nil, err
+	}
+	if dfs.canRotate() {
+		if err := dfs.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (af *ActiveFile) writeStream(meta *entity.Meta, key []byte, r io.Reader) (h *entity.Hint, err error) {
+	start := af.off
+	defer func() {
+		if err != nil {
+			_ = af.fd.Truncate(start)
+		}
+	}()
+	head := make([]byte, entity.MetaSize+len(key))
+	meta.Encode(head)
+	copy(head[entity.MetaSize:], key)
+	crc := crc32.ChecksumIEEE(head[4:])
+	if err := af.writeAt(head, start); err != nil {
+		return nil, err
+	}
+
+	off := start + int64(len(head))
+	chunk := make([]byte, streamChunk)
+	for left := int64(meta.ValueSize); left > 0; {
+		n := int64(len(chunk))
+		if left < n {
+			n = left
+		}
+		if _, err := io.ReadFull(r, chunk[:n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if err := af.writeAt(chunk[:n], off); err != nil {
+			return nil, err
+		}
+		crc = crc32.Update(crc, crc32.IEEETable, chunk[:n])
+		off += n
+		left -= n
+	}
+
+	sum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sum, crc)
+	if err := af.writeAt(sum, start); err != nil {
+		return nil, err
+	}
+	af.off = off
+	return entity.NewHint().WithFid(af.fid).WithOff(start), nil
+}
+
+func (af *ActiveFile) writeAt(buf []byte, off int64) error {
+	n, err := af.fd.WriteAt(buf, off)
+	if n < len(buf) {
+		return WriteMissDataErr
+	}
+	return err
+}
+
+// ValueReader reads one record's value straight from its segment. With CRC
+// verification on, the record CRC is checked when the end of the value is
+// reached: bytes read in order are hashed as they go, and any not yet hashed
+// (after a Seek) are read once more at that point.
+type ValueReader struct {
+	r    io.ReaderAt
+	base int64 // segment offset of the first value byte
+	size int64
+	pos  int64
+
+	verify   bool
+	want     uint32
+	crc      uint32 // CRC of meta, key and value[:hashed]
+	hashed   int64
+	verified bool
+	failures *atomic.Uint64 // DataFiles CRC failure counter
+
+	onClose func() error
+	closed  bool
+}
+
+// OpenValue returns a reader over the value of the record at (fid, off).
+// onClose runs once on Close. The reader stays valid only while the segment's
+// file is open.
+func (dfs *DataFiles) OpenValue(fid int, off int64, keySize, valueSize int, onClose func() error) (*ValueReader, error) {
+	seg, err := dfs.segmentOf(fid)
+	if err != nil {
+		return nil, err
+	}
+	r := seg.fd
+	head := make([]byte, seg.metaSize()+keySize)
+	n, err := r.ReadAt(head, off)
+	if n < len(head) {
+		return nil, ReadMissDataErr
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &ValueReader{
+		r:        r,
+		base:     off + int64(len(head)),
+		size:     int64(valueSize),
+		verify:   dfs.verifyCRC,
+		want:     binary.LittleEndian.Uint32(head[0:4]),
+		crc:      crc32.ChecksumIEEE(head[4:]),
+		onClose:  onClose,
+		failures: &dfs.crcFailures,
+	}, nil
+}
+
+// Size returns the value length.
+func (vr *ValueReader) Size() int64 {
+	return vr.size
+}
+
+func (vr *ValueReader) Read(p []byte) (int, error) {
+	if vr.closed {
+		return 0, errors.New("storage: read of closed value reader")
+	}
+	if vr.pos >= vr.size {
+		if err := vr.check(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	if left := vr.size - vr.pos; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := vr.r.ReadAt(p, vr.base+vr.pos)
+	if n < len(p) {
+		if err == nil || err == io.EOF {
+			err = ReadMissDataErr
+		}
+		return n, err
+	}
+	if vr.verify && vr.pos == vr.hashed {
+		vr.crc = crc32.Update(vr.crc, crc32.IEEETable, p[:n])
+		vr.hashed += int64(n)
+	}
+	vr.pos += int64(n)
+	if vr.pos == vr.size {
+		if err := vr.check(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// check finishes hashing the value and compares it with the stored CRC.
+func (vr *ValueReader) check() error {
+	if !vr.verify || vr.verified {
+		return nil
+	}
+	buf := make([]byte, streamChunk)
+	for vr.hashed < vr.size {
+		chunk := buf
+		if left := vr.size - vr.hashed; int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+		n, err := vr.r.ReadAt(chunk, vr.base+vr.hashed)
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = ReadMissDataErr
+			}
+			return err
+		}
+		vr.crc = crc32.Update(vr.crc, crc32.IEEETable, chunk)
+		vr.hashed += int64(n)
+	}
+	if vr.crc != vr.want {
+		vr.failures.Add(1)
+		return CrcErr
+	}
+	vr.verified = true
+	return nil
+}
+
+func (vr *ValueReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = vr.pos + offset
+	case io.SeekEnd:
+		abs = vr.size + offset
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	vr.pos = abs
+	return abs, nil
+}
+
+// Close releases the reader; further reads fail.
+func (vr *ValueReader) Close() error {
+	if vr.closed {
+		return nil
+	}
+	vr.closed = true
+	if vr.onClose != nil {
+		return vr.onClose()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"sync"
+
+	"tiny-bitcask/entity"
+)
+
+// isTombstone reports whether flag marks a point or range tombstone, the
+// records merge drops once nothing older is left for them to shadow.
+func isTombstone(flag uint8) bool {
+	return flag == entity.DeleteFlag || flag == entity.RangeDeleteFlag
+}
+
+// tombstoneCounts caches the number of committed tombstones in each segment.
+// A segment is counted the first time it is asked for, from its hint file
+// when it has one, and the active segment's count then follows its appends.
+type tombstoneCounts struct {
+	mu sync.Mutex
+	n  map[int]int // fid -> count; absent until counted
+}
+
+// added records tombstones appended to segment fid, if it has been counted.
+func (tc *tombstoneCounts) added(fid int, n int) {
+	if n == 0 {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if c, ok := tc.n[fid]; ok {
+		tc.n[fid] = c + n
+	}
+}
+
+// forget drops the count of a removed segment.
+func (tc *tombstoneCounts) forget(fid int) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	delete(tc.n, fid)
+}
+
+// Tombstones returns the number of committed tombstones in segment fid. The
+// first call for a segment reads its hint file, or scans it when there is
+// none. Caller must exclude concurrent writes.
+func (dfs *DataFiles) Tombstones(fid int) (int, error) {
+	tc := &dfs.tombs
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if c, ok := tc.n[fid]; ok {
+		return c, nil
+	}
+	var c int
+	hrs, _, err := ReadHintFile(dfs.dir, fid)
+	if fid != dfs.active.fid && err == nil {
+		for _, r := range hrs {
+			if isTombstone(r.Flag) {
+				c++
+			}
+		}
+	} else {
+		seg, err := dfs.segmentOf(fid)
+		if err != nil {
+			return 0, err
+		}
+		of := &OldFile{segment: *seg}
+		scan := of.Scan
+		if fid == dfs.active.fid {
+			scan = of.ScanActive
+		}
+		if _, err := scan(0, func(_ int64, e *entity.Entry) error {
+			if isTombstone(e.Meta.Flag) {
+				c++
+			}
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	if tc.n == nil {
+		tc.n = map[int]int{}
+	}
+	tc.n[fid] = c
+	return c, nil
+}
@@ -0,0 +1,86 @@
+package tiny_bitcask
+
+import (
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+)
+
+// SetReader sets key to exactly size bytes read from r, streaming them into the
+// active segment in fixed-size chunks with the CRC computed incrementally, so
+// the value is never buffered whole. If r ends early nothing is stored. The
+// write lock is held for the whole copy. With Options.Indexes set, the value is
+// read back once to feed the index functions.
+func (db *DB) SetReader(key []byte, r io.Reader, size int64) error {
+	if size < 0 || size > math.MaxUint32 {
+		return errors.New("tiny-bitcask: value size out of range")
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	if err := db.checkKeydirLimit(key); err != nil {
+		return err
+	}
+	meta := entity.NewMeta().
+		WithTimeStamp(uint64(time.Now().Unix())).
+		WithKeySize(uint32(len(key))).
+		WithValueSize(uint32(size))
+	h, err := db.storage.WriteStream(meta, key, r)
+	if err != nil {
+		return err
+	}
+	entry := entity.NewEntry().WithKey(key).WithMeta(meta)
+	if len(db.indexes) > 0 {
+		stored, err := db.storage.ReadEntry(&index.DataPosition{
+			Fid: h.Fid, Off: h.Off, KeySize: len(key), ValueSize: int(size),
+		})
+		if err != nil {
+			return err
+		}
+		entry.Value = stored.Value
+	}
+	return db.applyPut(h, entry)
+}
+
+// GetReader returns a reader over key's value as stored on disk, without
+// loading it into memory. With Options.VerifyCRC the record CRC is checked when
+// the reader reaches the end of the value; a mismatch is returned instead of
+// io.EOF. The reader pins the record's segment so merge cannot delete it; Close
+// it when done. It must not be used after DB.Close.
+func (db *DB) GetReader(key []byte) (io.ReadSeekCloser, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	dp := db.find(string(key))
+	if dp == nil {
+		db.rw.RUnlock()
+		return nil, KeyNotFoundErr
+	}
+	fid := dp.Fid
+	db.storage.Pin(fid)
+	unpin := func() error {
+		db.rw.Lock()
+		defer db.rw.Unlock()
+		if db.storage == nil {
+			return nil
+		}
+		return db.storage.Unpin(fid)
+	}
+	vr, err := db.storage.OpenValue(fid, dp.Off, dp.KeySize, dp.ValueSize, unpin)
+	db.rw.RUnlock()
+	if err != nil {
+		// Like Close: the last Unpin may delete a retired segment, which needs
+		// the write lock.
+		_ = unpin()
+		return nil, err
+	}
+	return vr, nil
+}
@@ -0,0 +1,166 @@
+package tiny_bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/entity"
+	"tiny-bitcask/storage"
+)
+
+func TestStream_RoundTrip(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	value := make([]byte, 300*1024+17)
+	rand.New(rand.NewSource(1)).Read(value)
+
+	require.NoError(t, db.SetReader([]byte("blob"), bytes.NewReader(value), int64(len(value))))
+	got, err := db.Get([]byte("blob"))
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	r, err := db.GetReader([]byte("blob"))
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, value, all)
+
+	_, err = r.Seek(-100, io.SeekEnd)
+	require.NoError(t, err)
+	tail, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, value[len(value)-100:], tail)
+	require.NoError(t, r.Close())
+
+	// A reader that only ever seeks past the start still verifies at EOF.
+	r, err = db.GetReader([]byte("blob"))
+	require.NoError(t, err)
+	defer r.Close()
+	_, err = r.Seek(1000, io.SeekStart)
+	require.NoError(t, err)
+	part, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, value[1000:], part)
+}
+
+func TestStream_ShortReaderStoresNothing(t *testing.T) {
+	db := newTestDB(t, nil)
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	off := db.storage.ActiveOffset()
+
+	err := db.SetReader([]byte("blob"), bytes.NewReader(make([]byte, 10)), 100)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.False(t, db.Has([]byte("blob")))
+	assert.Equal(t, off, db.storage.ActiveOffset())
+
+	require.NoError(t, db.Set([]byte("b"), []byte("2")))
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	db, err = NewDB(&Options{Dir: dir, VerifyCRC: true})
+	require.NoError(t, err)
+	defer db.Close()
+	got, err := db.Get([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(got))
+}
+
+func TestStream_CRCCheckedAtEOF(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	value := bytes.Repeat([]byte("x"), 200*1024)
+	require.NoError(t, db.SetReader([]byte("blob"), bytes.NewReader(value), int64(len(value))))
+
+	path := storage.DataFilePath(db.opt.Dir, 1)
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw[len(raw)-10] ^= 0xFF
+	require.NoError(t, os.WriteFile(path, raw, 0o644))
+
+	r, err := db.GetReader([]byte("blob"))
+	require.NoError(t, err)
+	defer r.Close()
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, storage.CrcErr)
+}
+
+// TestStream_TornTailRecovered simulates a crash after a streamed value was
+// written but before its CRC: recovery must drop the record, not fail to open.
+func TestStream_TornTailRecovered(t *testing.T) {
+	db := newTestDB(t, nil)
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+
+	torn := entity.NewEntryWithData([]byte("blob"), []byte("value")).Encode()
+	copy(torn[0:4], []byte{0, 0, 0, 0})
+	f, err := os.OpenFile(storage.DataFilePath(dir, 1), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write(torn)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db, err = NewDB(&Options{Dir: dir, VerifyCRC: true})
+	require.NoError(t, err)
+	defer db.Close()
+	assert.False(t, db.Has([]byte("blob")))
+	got, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(got))
+}
+
+// TestStream_SealedBadLastRecordFails flips a bit in the final record of a
+// sealed segment: unlike a torn active tail, recovery must report it.
+func TestStream_SealedBadLastRecordFails(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	for i := 0; len(db.storage.GetOldFiles()) == 0; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("k%03d", i)), bytes.Repeat([]byte("v"), 200)))
+	}
+	opt := *db.opt
+	require.NoError(t, db.Close())
+
+	// Without the hint, recovery scans the sealed segment.
+	storage.RemoveHintFile(opt.Dir, 1)
+	dat := storage.DataFilePath(opt.Dir, 1)
+	b, err := os.ReadFile(dat)
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xFF
+	require.NoError(t, os.WriteFile(dat, b, 0o644))
+
+	_, err = NewDB(&opt)
+	assert.ErrorIs(t, err, storage.CrcErr)
+}
+
+func TestStream_ReaderPinsSegmentAcrossMerge(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	defer db.Close()
+	value := bytes.Repeat([]byte("v"), 3000)
+	require.NoError(t, db.SetReader([]byte("blob"), bytes.NewReader(value), int64(len(value))))
+	r, err := db.GetReader([]byte("blob"))
+	require.NoError(t, err)
+
+	filler := bytes.Repeat([]byte("f"), 1000)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Set([]byte("filler"), filler))
+	}
+	require.NoError(t, db.Merge())
+
+	obsolete := storage.DataFilePath(db.opt.Dir, 1) + storage.ObsoleteSuffix
+	_, err = os.Stat(obsolete)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+	require.NoError(t, r.Close())
+	_, err = os.Stat(obsolete)
+	assert.True(t, os.IsNotExist(err))
+}
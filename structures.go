@@ -0,0 +1,622 @@
+package tiny_bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+)
+
+var (
+	InvalidScoreErr = errors.New("score must not be NaN")
+)
+
+// Hashes, sets, lists and sorted sets are stored as plain records under
+// composite keys. Every such key starts with dsKeyPrefix (reserved: plain
+// writes under it fail with ReservedKeyErr), a type byte and the
+// structure's key preceded by its length (4 bytes, big-endian), so structures
+// of different types or names never share keys:
+//
+//	hash field    P 'h' len key field                   -> value
+//	hash count    P 'H' len key                         -> field count (int64 LE)
+//	set member    P 's' len key member                  -> empty
+//	set count     P 'S' len key                         -> member count (int64 LE)
+//	list header   P 'l' len key                         -> head, tail (int64 LE each)
+//	list element  P 'l' len key index                   -> value
+//	zset member   P 'z' len key 'm' member              -> score (float64 bits, LE)
+//	zset score    P 'z' len key 's' sortableScore member -> empty
+//	zset count    P 'Z' len key                         -> member count (int64 LE)
+//
+// A list holds elements at indexes [head, tail); index is the int64 with its
+// sign bit flipped, big-endian, so elements sort in list order. sortableScore
+// is a float64 encoded so that byte order matches numeric order. Each
+// operation reads and writes under the DB write lock and commits its records
+// as one atomic batch, including the count header of a hash, set or sorted set
+// whose size changes, so HLen, SCard and ZCard read one record. A count header
+// is deleted when its structure becomes empty. Listing a hash, set or score
+// range walks the keydir once, sorting only the keys it collects.
+const dsKeyPrefix = "\x00ds\x00"
+
+const (
+	dsHash = 'h'
+	dsSet  = 's'
+	dsList = 'l'
+	dsZSet = 'z'
+
+	dsHashCount = 'H'
+	dsSetCount  = 'S'
+	dsZSetCount = 'Z'
+)
+
+// dsKey returns the composite key prefix of structure key of type typ,
+// followed by the parts.
+func dsKey(typ byte, key []byte, parts ...[]byte) []byte {
+	n := len(dsKeyPrefix) + 5 + len(key)
+	for _, p := range parts {
+		n += len(p)
+	}
+	buf := make([]byte, 0, n)
+	buf = append(buf, dsKeyPrefix...)
+	buf = append(buf, typ)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(key)))
+	buf = append(buf, key...)
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// dsUpdate runs fn under the write lock and commits the records it returns as
+// one batch; a single record skips the batch framing.
+func (db *DB) dsUpdate(fn func() ([]*entity.Entry, error)) error {
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	entries, err := fn()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 1 {
+		if entries[0].Meta.Flag == entity.DeleteFlag {
+			return db.remove(entries[0].Key)
+		}
+		_, err := db.putEntry(entries[0])
+		return err
+	}
+	return db.writeEntries(entries)
+}
+
+// valueOf returns a copy of the live value of key, or KeyNotFoundErr. Caller
+// holds db.rw.
+func (db *DB) valueOf(key []byte) ([]byte, error) {
+	dp := db.find(string(key))
+	if dp == nil {
+		return nil, KeyNotFoundErr
+	}
+	value, release, err := db.storage.ReadValue(dp)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return cloneBytes(value), nil
+}
+
+// prefixKeys returns the live keys starting with prefix in order. Caller holds
+// db.rw.
+func (db *DB) prefixKeys(prefix []byte) ([]string, error) {
+	return db.liveRangeKeys(prefix, prefixEnd(prefix))
+}
+
+// liveRangeKeys returns the live keys in [start, end) in order. The in-memory
+// keydir seeks straight to start, so a structure read costs its own keys
+// however many others the DB holds; an on-disk keydir, which keeps no order,
+// still scans its table for them. Caller holds db.rw.
+func (db *DB) liveRangeKeys(start, end []byte) ([]string, error) {
+	keys, err := index.RangeKeys(db.kd, start, end)
+	if err != nil {
+		return nil, err
+	}
+	live := keys[:0]
+	for _, k := range keys {
+		if db.find(k) != nil {
+			live = append(live, k)
+		}
+	}
+	return live, nil
+}
+
+// dsCount reads the count header of structure key of type typ (dsHashCount,
+// dsSetCount or dsZSetCount); an absent header counts 0. Caller holds db.rw.
+func (db *DB) dsCount(typ byte, key []byte) (int64, error) {
+	v, err := db.valueOf(dsKey(typ, key))
+	if err == KeyNotFoundErr {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, errors.New("corrupt structure count")
+	}
+	return int64(binary.LittleEndian.Uint64(v)), nil
+}
+
+// dsCountEntry writes count n to the header of structure key of type typ, or
+// deletes the header once the structure is empty.
+func dsCountEntry(typ byte, key []byte, n int64) *entity.Entry {
+	k := dsKey(typ, key)
+	if n <= 0 {
+		return entity.NewTombstoneEntry(k)
+	}
+	return entity.NewEntryWithData(k, binary.LittleEndian.AppendUint64(nil, uint64(n)))
+}
+
+// withCount appends to entries the count header update of structure key of
+// type typ for a change of delta members. Caller holds db.rw.
+func (db *DB) withCount(entries []*entity.Entry, typ byte, key []byte, delta int64) ([]*entity.Entry, error) {
+	if delta == 0 {
+		return entries, nil
+	}
+	n, err := db.dsCount(typ, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, dsCountEntry(typ, key, n+delta)), nil
+}
+
+// dsLen reads a count header for HLen, SCard and ZCard, which report 0 when it
+// cannot be read.
+func (db *DB) dsLen(typ byte, key []byte) int {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	n, err := db.dsCount(typ, key)
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// Hashes.
+
+// HSet sets field of hash key to value.
+func (db *DB) HSet(key, field, value []byte) error {
+	return db.dsUpdate(func() ([]*entity.Entry, error) {
+		k := dsKey(dsHash, key, field)
+		entries := []*entity.Entry{entity.NewEntryWithData(k, cloneBytes(value))}
+		if db.find(string(k)) != nil {
+			return entries, nil
+		}
+		return db.withCount(entries, dsHashCount, key, 1)
+	})
+}
+
+// HGet returns field of hash key, or KeyNotFoundErr.
+func (db *DB) HGet(key, field []byte) ([]byte, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	return db.valueOf(dsKey(dsHash, key, field))
+}
+
+// HDel removes fields from hash key and returns how many existed.
+func (db *DB) HDel(key []byte, fields ...[]byte) (int, error) {
+	var n int
+	err := db.dsUpdate(func() ([]*entity.Entry, error) {
+		var entries []*entity.Entry
+		seen := map[string]bool{}
+		for _, f := range fields {
+			k := dsKey(dsHash, key, f)
+			if seen[string(k)] || db.find(string(k)) == nil {
+				continue
+			}
+			seen[string(k)] = true
+			entries = append(entries, entity.NewTombstoneEntry(k))
+		}
+		n = len(entries)
+		return db.withCount(entries, dsHashCount, key, -int64(n))
+	})
+	return n, err
+}
+
+// HGetAll returns every field of hash key; an absent hash is empty.
+func (db *DB) HGetAll(key []byte) (map[string][]byte, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	prefix := dsKey(dsHash, key)
+	keys, err := db.prefixKeys(prefix)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]byte{}
+	for _, k := range keys {
+		v, err := db.valueOf([]byte(k))
+		if err != nil {
+			return nil, err
+		}
+		out[k[len(prefix):]] = v
+	}
+	return out, nil
+}
+
+// HLen returns the number of fields in hash key.
+func (db *DB) HLen(key []byte) int {
+	return db.dsLen(dsHashCount, key)
+}
+
+// Sets.
+
+// SAdd adds members to set key and returns how many were new.
+func (db *DB) SAdd(key []byte, members ...[]byte) (int, error) {
+	var n int
+	err := db.dsUpdate(func() ([]*entity.Entry, error) {
+		var entries []*entity.Entry
+		seen := map[string]bool{}
+		for _, m := range members {
+			k := dsKey(dsSet, key, m)
+			if seen[string(k)] || db.find(string(k)) != nil {
+				continue
+			}
+			seen[string(k)] = true
+			entries = append(entries, entity.NewEntryWithData(k, nil))
+		}
+		n = len(entries)
+		return db.withCount(entries, dsSetCount, key, int64(n))
+	})
+	return n, err
+}
+
+// SRem removes members from set key and returns how many existed.
+func (db *DB) SRem(key []byte, members ...[]byte) (int, error) {
+	var n int
+	err := db.dsUpdate(func() ([]*entity.Entry, error) {
+		var entries []*entity.Entry
+		seen := map[string]bool{}
+		for _, m := range members {
+			k := dsKey(dsSet, key, m)
+			if seen[string(k)] || db.find(string(k)) == nil {
+				continue
+			}
+			seen[string(k)] = true
+			entries = append(entries, entity.NewTombstoneEntry(k))
+		}
+		n = len(entries)
+		return db.withCount(entries, dsSetCount, key, -int64(n))
+	})
+	return n, err
+}
+
+// SIsMember reports whether member is in set key.
+func (db *DB) SIsMember(key, member []byte) bool {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	return db.find(string(dsKey(dsSet, key, member))) != nil
+}
+
+// SMembers returns the members of set key in byte order, or nil if an on-disk
+// keydir cannot be read.
+func (db *DB) SMembers(key []byte) [][]byte {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	prefix := dsKey(dsSet, key)
+	keys, err := db.prefixKeys(prefix)
+	if err != nil {
+		return nil
+	}
+	out := make([][]byte, len(keys))
+	for i, k := range keys {
+		out[i] = []byte(k[len(prefix):])
+	}
+	return out
+}
+
+// SCard returns the number of members of set key.
+func (db *DB) SCard(key []byte) int {
+	return db.dsLen(dsSetCount, key)
+}
+
+// Lists.
+
+// listHeader reads the [head, tail) bounds of list key; an absent list is
+// empty at 0. Caller holds db.rw.
+func (db *DB) listHeader(key []byte) (head, tail int64, err error) {
+	v, err := db.valueOf(dsKey(dsList, key))
+	if err == KeyNotFoundErr {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(v) != 16 {
+		return 0, 0, errors.New("corrupt list header")
+	}
+	return int64(binary.LittleEndian.Uint64(v[0:8])), int64(binary.LittleEndian.Uint64(v[8:16])), nil
+}
+
+// listHeaderEntry writes the bounds of list key, or deletes the header once
+// the list is empty.
+func listHeaderEntry(key []byte, head, tail int64) *entity.Entry {
+	k := dsKey(dsList, key)
+	if head == tail {
+		return entity.NewTombstoneEntry(k)
+	}
+	v := make([]byte, 16)
+	binary.LittleEndian.PutUint64(v[0:8], uint64(head))
+	binary.LittleEndian.PutUint64(v[8:16], uint64(tail))
+	return entity.NewEntryWithData(k, v)
+}
+
+func listElemKey(key []byte, i int64) []byte {
+	return dsKey(dsList, key, binary.BigEndian.AppendUint64(nil, uint64(i)^(1<<63)))
+}
+
+// LPush prepends values to list key one by one, so the last value ends up
+// first, and returns the new length.
+func (db *DB) LPush(key []byte, values ...[]byte) (int, error) {
+	return db.push(key, values, true)
+}
+
+// RPush appends values to list key and returns the new length.
+func (db *DB) RPush(key []byte, values ...[]byte) (int, error) {
+	return db.push(key, values, false)
+}
+
+func (db *DB) push(key []byte, values [][]byte, left bool) (int, error) {
+	var n int
+	err := db.dsUpdate(func() ([]*entity.Entry, error) {
+		head, tail, err := db.listHeader(key)
+		if err != nil || len(values) == 0 {
+			n = int(tail - head)
+			return nil, err
+		}
+		entries := make([]*entity.Entry, 0, len(values)+1)
+		for _, v := range values {
+			var i int64
+			if left {
+				head--
+				i = head
+			} else {
+				i = tail
+				tail++
+			}
+			entries = append(entries, entity.NewEntryWithData(listElemKey(key, i), cloneBytes(v)))
+		}
+		n = int(tail - head)
+		return append(entries, listHeaderEntry(key, head, tail)), nil
+	})
+	return n, err
+}
+
+// LPop removes and returns the first element of list key, or KeyNotFoundErr
+// when it is empty.
+func (db *DB) LPop(key []byte) ([]byte, error) {
+	return db.pop(key, true)
+}
+
+// RPop removes and returns the last element of list key, or KeyNotFoundErr
+// when it is empty.
+func (db *DB) RPop(key []byte) ([]byte, error) {
+	return db.pop(key, false)
+}
+
+func (db *DB) pop(key []byte, left bool) ([]byte, error) {
+	var v []byte
+	err := db.dsUpdate(func() ([]*entity.Entry, error) {
+		head, tail, err := db.listHeader(key)
+		if err != nil {
+			return nil, err
+		}
+		if head == tail {
+			return nil, KeyNotFoundErr
+		}
+		var ek []byte
+		if left {
+			ek = listElemKey(key, head)
+			head++
+		} else {
+			tail--
+			ek = listElemKey(key, tail)
+		}
+		if v, err = db.valueOf(ek); err != nil {
+			return nil, err
+		}
+		return []*entity.Entry{entity.NewTombstoneEntry(ek), listHeaderEntry(key, head, tail)}, nil
+	})
+	return v, err
+}
+
+// LRange returns the elements of list key from start to stop inclusive.
+// Negative indexes count from the end (-1 is the last element) and
+// out-of-range bounds are clamped, as in Redis.
+func (db *DB) LRange(key []byte, start, stop int64) ([][]byte, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	head, tail, err := db.listHeader(key)
+	if err != nil {
+		return nil, err
+	}
+	n := tail - head
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return [][]byte{}, nil
+	}
+	out := make([][]byte, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		v, err := db.valueOf(listElemKey(key, head+i))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// LLen returns the length of list key.
+func (db *DB) LLen(key []byte) (int, error) {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	head, tail, err := db.listHeader(key)
+	return int(tail - head), err
+}
+
+// Sorted sets.
+
+// ZMember is a sorted set member with its score.
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+func zMemberKey(key, member []byte) []byte {
+	return dsKey(dsZSet, key, []byte{'m'}, member)
+}
+
+func zScoreKey(key []byte, score float64, member []byte) []byte {
+	return dsKey(dsZSet, key, []byte{'s'}, sortableScore(score), member)
+}
+
+// sortableScore encodes f so that byte order matches numeric order: the sign
+// bit is flipped for positives and every bit for negatives. -0 encodes as 0.
+func sortableScore(f float64) []byte {
+	if f == 0 {
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if bits&(1<<63) == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+// zScore reads member's score in sorted set key. Caller holds db.rw.
+func (db *DB) zScore(key, member []byte) (float64, error) {
+	v, err := db.valueOf(zMemberKey(key, member))
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, errors.New("corrupt sorted set score")
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(v)), nil
+}
+
+// ZAdd sets member's score in sorted set key and reports whether the member
+// is new.
+func (db *DB) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	if math.IsNaN(score) {
+		return false, InvalidScoreErr
+	}
+	var added bool
+	err := db.dsUpdate(func() ([]*entity.Entry, error) {
+		var entries []*entity.Entry
+		old, err := db.zScore(key, member)
+		switch {
+		case err == KeyNotFoundErr:
+			added = true
+		case err != nil:
+			return nil, err
+		case old == score:
+			return nil, nil
+		default:
+			entries = append(entries, entity.NewTombstoneEntry(zScoreKey(key, old, member)))
+		}
+		v := binary.LittleEndian.AppendUint64(nil, math.Float64bits(score))
+		entries = append(entries,
+			entity.NewEntryWithData(zMemberKey(key, member), v),
+			entity.NewEntryWithData(zScoreKey(key, score, member), nil),
+		)
+		if !added {
+			return entries, nil
+		}
+		return db.withCount(entries, dsZSetCount, key, 1)
+	})
+	return added, err
+}
+
+// ZScore returns member's score in sorted set key, or KeyNotFoundErr.
+func (db *DB) ZScore(key, member []byte) (float64, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	return db.zScore(key, member)
+}
+
+// ZRem removes members from sorted set key and returns how many existed.
+func (db *DB) ZRem(key []byte, members ...[]byte) (int, error) {
+	var n int
+	err := db.dsUpdate(func() ([]*entity.Entry, error) {
+		var entries []*entity.Entry
+		seen := map[string]bool{}
+		for _, m := range members {
+			if seen[string(m)] {
+				continue
+			}
+			score, err := db.zScore(key, m)
+			if err == KeyNotFoundErr {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			seen[string(m)] = true
+			entries = append(entries,
+				entity.NewTombstoneEntry(zMemberKey(key, m)),
+				entity.NewTombstoneEntry(zScoreKey(key, score, m)),
+			)
+			n++
+		}
+		return db.withCount(entries, dsZSetCount, key, -int64(n))
+	})
+	return n, err
+}
+
+// ZRangeByScore returns the members of sorted set key with min <= score <= max,
+// ordered by score and then member.
+func (db *DB) ZRangeByScore(key []byte, min, max float64) ([]ZMember, error) {
+	if math.IsNaN(min) || math.IsNaN(max) {
+		return nil, InvalidScoreErr
+	}
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	prefix := dsKey(dsZSet, key, []byte{'s'})
+	start := append(cloneBytes(prefix), sortableScore(min)...)
+	end := prefixEnd(append(cloneBytes(prefix), sortableScore(max)...))
+	keys, err := db.liveRangeKeys(start, end)
+	if err != nil {
+		return nil, err
+	}
+	var out []ZMember
+	for _, k := range keys {
+		member := []byte(k[len(prefix)+8:])
+		score, err := db.zScore(key, member)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ZMember{Member: member, Score: score})
+	}
+	return out, nil
+}
+
+// ZCard returns the number of members of sorted set key.
+func (db *DB) ZCard(key []byte) int {
+	return db.dsLen(dsZSetCount, key)
+}
@@ -0,0 +1,248 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/index"
+)
+
+func bs(ss ...string) [][]byte {
+	out := make([][]byte, len(ss))
+	for i, s := range ss {
+		out[i] = []byte(s)
+	}
+	return out
+}
+
+func TestStructures_Hash(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	k := []byte("user:1")
+	require.NoError(t, db.HSet(k, []byte("name"), []byte("Ann")))
+	require.NoError(t, db.HSet(k, []byte("age"), []byte("30")))
+	require.NoError(t, db.HSet([]byte("user:10"), []byte("name"), []byte("Bob")))
+
+	v, err := db.HGet(k, []byte("name"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("Ann"), v)
+	all, err := db.HGetAll(k)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"name": []byte("Ann"), "age": []byte("30")}, all)
+	assert.Equal(t, 2, db.HLen(k))
+
+	n, err := db.HDel(k, []byte("age"), []byte("missing"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, db.HLen(k))
+	_, err = db.Get(k)
+	assert.ErrorIs(t, err, KeyNotFoundErr, "structures do not use the plain key")
+}
+
+func TestStructures_Set(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	k := []byte("tags")
+	n, err := db.SAdd(k, bs("b", "a", "b", "c")...)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = db.SAdd(k, bs("a", "d")...)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, bs("a", "b", "c", "d"), db.SMembers(k))
+	assert.True(t, db.SIsMember(k, []byte("c")))
+
+	n, err = db.SRem(k, bs("c", "x")...)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.False(t, db.SIsMember(k, []byte("c")))
+	assert.Equal(t, 3, db.SCard(k))
+}
+
+func TestStructures_List(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	k := []byte("queue")
+	n, err := db.RPush(k, bs("c", "d")...)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = db.LPush(k, bs("b", "a")...)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	got, err := db.LRange(k, 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, bs("a", "b", "c", "d"), got)
+	got, err = db.LRange(k, -2, 10)
+	require.NoError(t, err)
+	assert.Equal(t, bs("c", "d"), got)
+	got, err = db.LRange(k, 3, 1)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	v, err := db.LPop(k)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), v)
+	v, err = db.RPop(k)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("d"), v)
+	l, err := db.LLen(k)
+	require.NoError(t, err)
+	assert.Equal(t, 2, l)
+
+	_, _ = db.LPop(k)
+	_, _ = db.LPop(k)
+	_, err = db.LPop(k)
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+	assert.Empty(t, db.ListKeys(), "an emptied list leaves no keys behind")
+}
+
+func TestStructures_SortedSet(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	k := []byte("scores")
+	for _, m := range []ZMember{{[]byte("c"), 3}, {[]byte("a"), -1.5}, {[]byte("b"), 3}, {[]byte("z"), 100}} {
+		added, err := db.ZAdd(k, m.Score, m.Member)
+		require.NoError(t, err)
+		assert.True(t, added)
+	}
+	added, err := db.ZAdd(k, 2, []byte("z"))
+	require.NoError(t, err)
+	assert.False(t, added)
+
+	got, err := db.ZRangeByScore(k, -2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []ZMember{{[]byte("a"), -1.5}, {[]byte("z"), 2}, {[]byte("b"), 3}, {[]byte("c"), 3}}, got)
+	got, err = db.ZRangeByScore(k, math.Inf(-1), math.Inf(1))
+	require.NoError(t, err)
+	assert.Len(t, got, 4)
+
+	score, err := db.ZScore(k, []byte("z"))
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, score)
+	n, err := db.ZRem(k, bs("z", "nope")...)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 3, db.ZCard(k))
+	got, err = db.ZRangeByScore(k, 2, 2)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	_, err = db.ZAdd(k, math.NaN(), []byte("n"))
+	assert.ErrorIs(t, err, InvalidScoreErr)
+}
+
+// TestStructures_Reopen checks structures, including multi-record updates
+// written as batches, survive recovery.
+func TestStructures_Reopen(t *testing.T) {
+	db := newTestDB(t, nil)
+	_, err := db.RPush([]byte("l"), bs("x", "y")...)
+	require.NoError(t, err)
+	_, err = db.ZAdd([]byte("z"), 1, []byte("m"))
+	require.NoError(t, err)
+	_, err = db.ZAdd([]byte("z"), 5, []byte("m"))
+	require.NoError(t, err)
+
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	db, err = NewDB(&Options{Dir: dir})
+	require.NoError(t, err)
+	defer db.Close()
+	got, err := db.LRange([]byte("l"), 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, bs("x", "y"), got)
+	zs, err := db.ZRangeByScore([]byte("z"), 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ZMember{{[]byte("m"), 5}}, zs)
+}
+
+// TestStructures_Counts checks the count headers behind HLen, SCard and ZCard
+// track every change, vanish once a structure is empty and survive reopen.
+func TestStructures_Counts(t *testing.T) {
+	db := newTestDB(t, nil)
+	h, s, z := []byte("h"), []byte("s"), []byte("z")
+	require.NoError(t, db.HSet(h, []byte(""), []byte("empty field")))
+	require.NoError(t, db.HSet(h, []byte("f"), []byte("1")))
+	require.NoError(t, db.HSet(h, []byte("f"), []byte("2")))
+	_, err := db.HDel(h, bs("f", "f", "missing")...)
+	require.NoError(t, err)
+	_, err = db.SAdd(s, bs("a", "b", "c")...)
+	require.NoError(t, err)
+	_, err = db.SRem(s, bs("a", "b", "c")...)
+	require.NoError(t, err)
+	for i, m := range []string{"a", "b", "a"} {
+		_, err = db.ZAdd(z, float64(i), []byte(m))
+		require.NoError(t, err)
+	}
+
+	check := func(db *DB) {
+		t.Helper()
+		assert.Equal(t, 1, db.HLen(h))
+		assert.Equal(t, 0, db.SCard(s))
+		assert.False(t, db.Has(dsKey(dsSetCount, s)), "an empty set keeps no header")
+		assert.Equal(t, 2, db.ZCard(z))
+	}
+	check(db)
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+	db, err = NewDB(&Options{Dir: dir})
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
+
+// countingIndex counts the keydir entries ordered reads visit and the full
+// passes made over it.
+type countingIndex struct {
+	index.Index
+	visited, ranges int
+}
+
+func (c *countingIndex) Range(fn func(key string, dp *index.DataPosition) bool) {
+	c.ranges++
+	c.Index.Range(fn)
+}
+
+func (c *countingIndex) Ascend(start, end string, fn func(key string, dp *index.DataPosition) bool) error {
+	return c.Index.Ascend(start, end, func(key string, dp *index.DataPosition) bool {
+		c.visited++
+		return fn(key, dp)
+	})
+}
+
+func TestStructures_ReadsSeekPastUnrelatedKeys(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("plain-%05d", i)), []byte("v")))
+	}
+	for i := 0; i < 5; i++ {
+		f := []byte(fmt.Sprintf("f%d", i))
+		require.NoError(t, db.HSet([]byte("h"), f, []byte("v")))
+		_, err := db.SAdd([]byte("s"), f)
+		require.NoError(t, err)
+		_, err = db.ZAdd([]byte("z"), float64(i), f)
+		require.NoError(t, err)
+		_, err = db.RPush([]byte("l"), f)
+		require.NoError(t, err)
+	}
+	kd := &countingIndex{Index: db.kd}
+	db.kd = kd
+
+	all, err := db.HGetAll([]byte("h"))
+	require.NoError(t, err)
+	assert.Len(t, all, 5)
+	assert.Len(t, db.SMembers([]byte("s")), 5)
+	zs, err := db.ZRangeByScore([]byte("z"), 1, 3)
+	require.NoError(t, err)
+	assert.Len(t, zs, 3)
+	vs, err := db.LRange([]byte("l"), 0, -1)
+	require.NoError(t, err)
+	assert.Len(t, vs, 5)
+
+	assert.Zero(t, kd.ranges, "structure reads must not scan the whole keydir")
+	assert.Equal(t, 5+5+3, kd.visited)
+}
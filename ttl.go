@@ -0,0 +1,89 @@
+package tiny_bitcask
+
+import (
+	"errors"
+	"time"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+)
+
+var (
+	InvalidTTLErr = errors.New("ttl must be positive")
+)
+
+// SetWithTTL sets key = value and makes it expire after ttl. The expiry is
+// stored in the record, so it survives restarts and merge: reads treat an
+// expired key as missing right away, the sweeper (Options.ExpirySweepInterval)
+// drops it from the keydir, and merge never copies it. A later Set of the key
+// clears the TTL.
+func (db *DB) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return InvalidTTLErr
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	entry := entity.NewEntryWithData(key, value)
+	entry.Meta.WithExpiry(uint64(time.Now().Add(ttl).UnixNano()))
+	_, err := db.putEntry(entry)
+	return err
+}
+
+// TTL returns how long key has left to live, or 0 if it has no expiry.
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	dp := db.find(string(key))
+	if dp == nil {
+		return 0, KeyNotFoundErr
+	}
+	if dp.Expiry == 0 {
+		return 0, nil
+	}
+	return time.Duration(int64(dp.Expiry) - time.Now().UnixNano()), nil
+}
+
+// sweepExpired drops every expired key from the keydir and secondary indexes.
+// No tombstone is written: the record's own expiry keeps it dead on recovery.
+func (db *DB) sweepExpired() error {
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.storage == nil {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	var expired []string
+	db.kd.Range(func(key string, dp *index.DataPosition) bool {
+		if dp.Expired(now) {
+			expired = append(expired, key)
+		}
+		return true
+	})
+	for _, k := range expired {
+		if err := db.applyDelete([]byte(k), OpExpire, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweepLoop runs sweepExpired every interval until Close.
+func (db *DB) sweepLoop(interval time.Duration) {
+	defer db.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-db.stopc:
+			return
+		case <-t.C:
+			_ = db.sweepExpired()
+		}
+	}
+}
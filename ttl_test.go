@@ -0,0 +1,121 @@
+package tiny_bitcask
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tiny-bitcask/index"
+	"tiny-bitcask/storage"
+)
+
+func TestTTL_LazyExpiryAndSweep(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+
+	require.NoError(t, db.SetWithTTL([]byte("session"), []byte("s"), 50*time.Millisecond))
+	require.NoError(t, db.SetWithTTL([]byte("long"), []byte("l"), time.Hour))
+	require.NoError(t, db.Set([]byte("plain"), []byte("p")))
+	assert.ErrorIs(t, db.SetWithTTL([]byte("x"), []byte("x"), 0), InvalidTTLErr)
+
+	got, err := db.Get([]byte("session"))
+	require.NoError(t, err)
+	assert.Equal(t, "s", string(got))
+	left, err := db.TTL([]byte("long"))
+	require.NoError(t, err)
+	assert.Greater(t, left, 59*time.Minute)
+	left, err = db.TTL([]byte("plain"))
+	require.NoError(t, err)
+	assert.Zero(t, left)
+
+	time.Sleep(80 * time.Millisecond)
+	_, err = db.Get([]byte("session"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+	assert.ErrorIs(t, db.Delete([]byte("session")), KeyNotFoundErr)
+	assert.Equal(t, []string{"long", "plain"}, keyStrings(db.ListKeys()))
+
+	// Still in the keydir until swept, but not counted as a key.
+	st := db.Stats()
+	assert.Equal(t, 2, st.KeyCount)
+	assert.Equal(t, 1, st.Expired)
+	require.NoError(t, db.sweepExpired())
+	st = db.Stats()
+	assert.Equal(t, 2, st.KeyCount)
+	assert.Zero(t, st.Expired)
+
+	// A plain Set clears the TTL.
+	require.NoError(t, db.SetWithTTL([]byte("long"), []byte("l"), time.Hour))
+	require.NoError(t, db.Set([]byte("long"), []byte("l2")))
+	left, err = db.TTL([]byte("long"))
+	require.NoError(t, err)
+	assert.Zero(t, left)
+}
+
+func TestTTL_BackgroundSweeper(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.ExpirySweepInterval = 10 * time.Millisecond
+	})
+	defer db.Close()
+	require.NoError(t, db.SetWithTTL([]byte("k"), []byte("v"), 20*time.Millisecond))
+	assert.Eventually(t, func() bool {
+		return db.Stats().KeyCount == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestTTL_SurvivesRecoveryAndMerge checks expiry travels through hint files and
+// recovery, and that merge drops expired records instead of copying them.
+func TestTTL_SurvivesRecoveryAndMerge(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	val := []byte(fmt.Sprintf("%0100d", 0))
+	for i := 0; i < 60; i++ {
+		key := []byte(fmt.Sprintf("short-%02d", i))
+		require.NoError(t, db.SetWithTTL(key, val, 100*time.Millisecond))
+		require.NoError(t, db.SetWithTTL([]byte(fmt.Sprintf("long-%02d", i)), val, time.Hour))
+	}
+	require.Greater(t, len(db.storage.GetOldFiles()), 2)
+	dir := db.opt.Dir
+	require.NoError(t, db.Close())
+
+	opt := &Options{Dir: dir, SegmentSize: 4 * storage.KB, VerifyCRC: true}
+	db, err := NewDB(opt)
+	require.NoError(t, err)
+	left, err := db.TTL([]byte("long-00"))
+	require.NoError(t, err)
+	assert.Greater(t, left, 59*time.Minute)
+	_, err = db.Get([]byte("short-00"))
+	require.NoError(t, err)
+
+	time.Sleep(150 * time.Millisecond)
+	require.NoError(t, db.Merge())
+	// Merge dropped the expired keys it came across instead of copying them.
+	assert.Less(t, countKeydir(db, "short-"), 60)
+	require.NoError(t, db.Close())
+
+	db, err = NewDB(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	for i := 0; i < 60; i++ {
+		_, err := db.Get([]byte(fmt.Sprintf("short-%02d", i)))
+		assert.ErrorIs(t, err, KeyNotFoundErr)
+		_, err = db.Get([]byte(fmt.Sprintf("long-%02d", i)))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 60, db.Stats().KeyCount)
+}
+
+// countKeydir counts keydir entries with prefix, expired or not.
+func countKeydir(db *DB, prefix string) int {
+	n := 0
+	db.kd.Range(func(k string, _ *index.DataPosition) bool {
+		if strings.HasPrefix(k, prefix) {
+			n++
+		}
+		return true
+	})
+	return n
+}
@@ -0,0 +1,178 @@
+package tiny_bitcask
+
+import (
+	"errors"
+
+	"tiny-bitcask/entity"
+	"tiny-bitcask/index"
+)
+
+var (
+	ConflictErr    = errors.New("transaction conflict")
+	TxnReadOnlyErr = errors.New("write in read-only transaction")
+	TxnDoneErr     = errors.New("transaction already finished")
+)
+
+// Txn is an optimistic transaction. Reads go straight to the DB and record the
+// Version (or absence) of each key they see; writes are buffered. At commit the
+// recorded versions are checked under the write lock and, if any key changed
+// since it was read, the commit fails with ConflictErr and nothing is written.
+// A version is the record's sequence number, which merge keeps, so moving a
+// record does not count as a change.
+//
+// A Txn is only valid inside the Update or View callback that received it and
+// is not safe for concurrent use.
+type Txn struct {
+	db       *DB
+	writable bool
+	done     bool
+	reads    map[string]Version // NoVersion: read as absent
+	writes   map[string]*entity.Entry
+	entries  []*entity.Entry
+}
+
+// Update runs fn in a read-write transaction and commits its writes atomically
+// through the batch path if fn returns nil. It returns fn's error, or
+// ConflictErr if a key fn read was changed by another writer before commit.
+func (db *DB) Update(fn func(tx *Txn) error) error {
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	tx := db.newTxn(true)
+	defer func() { tx.done = true }()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// View runs fn in a read-only transaction; Set and Delete fail with
+// TxnReadOnlyErr. Each read is read-committed; nothing is validated at the end.
+func (db *DB) View(fn func(tx *Txn) error) error {
+	tx := db.newTxn(false)
+	defer func() { tx.done = true }()
+	return fn(tx)
+}
+
+func (db *DB) newTxn(writable bool) *Txn {
+	return &Txn{
+		db:       db,
+		writable: writable,
+		reads:    map[string]Version{},
+		writes:   map[string]*entity.Entry{},
+	}
+}
+
+// Get returns the value of key, seeing the transaction's own pending writes.
+func (tx *Txn) Get(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, TxnDoneErr
+	}
+	if e, ok := tx.writes[string(key)]; ok {
+		if e.Meta.Flag == entity.DeleteFlag {
+			return nil, KeyNotFoundErr
+		}
+		return cloneBytes(e.Value), nil
+	}
+	db := tx.db
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	k := string(key)
+	dp := db.find(k)
+	tx.recordRead(k, dp)
+	if dp == nil {
+		return nil, KeyNotFoundErr
+	}
+	entry, err := db.storage.ReadEntry(dp)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+// Has reports whether key exists, seeing pending writes. Like Get it makes the
+// commit conflict if another writer adds, changes or removes key.
+func (tx *Txn) Has(key []byte) (bool, error) {
+	if tx.done {
+		return false, TxnDoneErr
+	}
+	if e, ok := tx.writes[string(key)]; ok {
+		return e.Meta.Flag != entity.DeleteFlag, nil
+	}
+	db := tx.db
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	k := string(key)
+	dp := db.find(k)
+	tx.recordRead(k, dp)
+	return dp != nil, nil
+}
+
+// Set buffers key = value until commit. key and value are copied.
+func (tx *Txn) Set(key, value []byte) error {
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	tx.buffer(entity.NewEntryWithData(cloneBytes(key), cloneBytes(value)))
+	return nil
+}
+
+// Delete buffers removal of key until commit. Deleting a missing key is not an
+// error.
+func (tx *Txn) Delete(key []byte) error {
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	tx.buffer(entity.NewTombstoneEntry(cloneBytes(key)))
+	return nil
+}
+
+func (tx *Txn) checkWrite() error {
+	if tx.done {
+		return TxnDoneErr
+	}
+	if !tx.writable {
+		return TxnReadOnlyErr
+	}
+	return nil
+}
+
+func (tx *Txn) buffer(e *entity.Entry) {
+	tx.writes[string(e.Key)] = e
+	tx.entries = append(tx.entries, e)
+}
+
+// recordRead keeps the first version observed for key; later reads of the
+// same key are covered by validating that one.
+func (tx *Txn) recordRead(key string, dp *index.DataPosition) {
+	if _, seen := tx.reads[key]; seen {
+		return
+	}
+	tx.reads[key] = versionOf(dp)
+}
+
+// commit validates the read set and writes the buffered entries as one batch.
+func (tx *Txn) commit() error {
+	if len(tx.entries) == 0 {
+		return nil
+	}
+	db := tx.db
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.storage == nil {
+		return DBClosedErr
+	}
+	for key, ver := range tx.reads {
+		if versionOf(db.find(key)) != ver {
+			return ConflictErr
+		}
+	}
+	return db.writeEntries(tx.entries)
+}
@@ -0,0 +1,164 @@
+package tiny_bitcask
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tiny-bitcask/storage"
+)
+
+func TestTxn_ReadYourWrites(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+
+	err := db.Update(func(tx *Txn) error {
+		require.NoError(t, tx.Set([]byte("b"), []byte("2")))
+		require.NoError(t, tx.Delete([]byte("a")))
+		v, err := tx.Get([]byte("b"))
+		require.NoError(t, err)
+		assert.Equal(t, "2", string(v))
+		_, err = tx.Get([]byte("a"))
+		assert.ErrorIs(t, err, KeyNotFoundErr)
+
+		// Nothing is visible outside the transaction before commit.
+		_, err = db.Get([]byte("b"))
+		assert.ErrorIs(t, err, KeyNotFoundErr)
+		return nil
+	})
+	require.NoError(t, err)
+
+	v, err := db.Get([]byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(v))
+	_, err = db.Get([]byte("a"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+
+	err := db.Update(func(tx *Txn) error {
+		_, err := tx.Get([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, db.Set([]byte("a"), []byte("other")))
+		return tx.Set([]byte("b"), []byte("2"))
+	})
+	assert.ErrorIs(t, err, ConflictErr)
+	_, err = db.Get([]byte("b"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+
+	// A key read as absent conflicts when another writer creates it.
+	err = db.Update(func(tx *Txn) error {
+		ok, err := tx.Has([]byte("c"))
+		require.NoError(t, err)
+		assert.False(t, ok)
+		require.NoError(t, db.Set([]byte("c"), []byte("3")))
+		return tx.Set([]byte("c"), []byte("mine"))
+	})
+	assert.ErrorIs(t, err, ConflictErr)
+	v, err := db.Get([]byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(v))
+}
+
+func TestTxn_MergeIsNotAConflict(t *testing.T) {
+	db := newTestDB(t, func(o *Options) {
+		o.SegmentSize = 4 * storage.KB
+	})
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+	for i := 0; len(db.storage.GetOldFiles()) < 3; i++ {
+		require.NoError(t, db.Set([]byte(fmt.Sprintf("fill-%03d", i%20)), make([]byte, 200)))
+	}
+
+	err := db.Update(func(tx *Txn) error {
+		v, err := tx.Get([]byte("a"))
+		require.NoError(t, err)
+		before := *db.kd.Find("a")
+		require.NoError(t, db.Merge())
+		require.False(t, db.kd.Find("a").IsEqualPos(before.Fid, before.Off), "merge must move the record")
+		return tx.Set([]byte("a"), append(v, '2'))
+	})
+	require.NoError(t, err)
+	v, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "12", string(v))
+}
+
+func TestTxn_ViewAndErrors(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("a"), []byte("1")))
+
+	var leaked *Txn
+	err := db.View(func(tx *Txn) error {
+		leaked = tx
+		v, err := tx.Get([]byte("a"))
+		require.NoError(t, err)
+		assert.Equal(t, "1", string(v))
+		assert.ErrorIs(t, tx.Set([]byte("a"), []byte("2")), TxnReadOnlyErr)
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = leaked.Get([]byte("a"))
+	assert.ErrorIs(t, err, TxnDoneErr)
+
+	boom := errors.New("boom")
+	err = db.Update(func(tx *Txn) error {
+		require.NoError(t, tx.Set([]byte("a"), []byte("2")))
+		return boom
+	})
+	assert.ErrorIs(t, err, boom)
+	v, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(v))
+}
+
+// TestTxn_ConcurrentIncrements retries on conflict; no increment may be lost.
+func TestTxn_ConcurrentIncrements(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	require.NoError(t, db.Set([]byte("n"), []byte("0")))
+
+	const workers, each = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				for {
+					err := db.Update(func(tx *Txn) error {
+						v, err := tx.Get([]byte("n"))
+						if err != nil {
+							return err
+						}
+						n, err := strconv.Atoi(string(v))
+						if err != nil {
+							return err
+						}
+						return tx.Set([]byte("n"), []byte(strconv.Itoa(n+1)))
+					})
+					if err == ConflictErr {
+						continue
+					}
+					assert.NoError(t, err)
+					break
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	v, err := db.Get([]byte("n"))
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(workers*each), string(v))
+}
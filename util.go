@@ -24,3 +24,21 @@ func getSegmentSize(size int64) int64 {
 	}
 	return fileSize
 }
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil when no such bound exists (empty or all-0xFF prefix).
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// cloneBytes returns a copy of b that does not alias the caller's slice.
+func cloneBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
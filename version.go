@@ -0,0 +1,90 @@
+package tiny_bitcask
+
+import (
+	"errors"
+
+	"tiny-bitcask/index"
+)
+
+var (
+	VersionMismatchErr = errors.New("version mismatch")
+)
+
+// Version identifies the record a key currently points at: it is the record's
+// sequence number, so it changes on every Set or Delete of the key, orders
+// writes across keys, and survives merge and reopen. NoVersion stands for
+// "key absent".
+type Version uint64
+
+const NoVersion Version = 0
+
+func versionOf(dp *index.DataPosition) Version {
+	if dp == nil {
+		return NoVersion
+	}
+	return Version(dp.Seq)
+}
+
+// GetWithVersion returns the value of key and its current Version.
+func (db *DB) GetWithVersion(key []byte) ([]byte, Version, error) {
+	db.counters.gets.Add(1)
+	db.rw.RLock()
+	defer db.rw.RUnlock()
+	dp := db.find(string(key))
+	if dp == nil {
+		return nil, NoVersion, KeyNotFoundErr
+	}
+	entry, err := db.storage.ReadEntry(dp)
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	return entry.Value, versionOf(dp), nil
+}
+
+// SetIfVersion sets key = value only if key's current Version is expected
+// (NoVersion: key must be absent), returning the new Version. Otherwise it
+// fails with VersionMismatchErr and writes nothing.
+func (db *DB) SetIfVersion(key, value []byte, expected Version) (Version, error) {
+	if err := checkKey(key); err != nil {
+		return NoVersion, err
+	}
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return NoVersion, ReadOnlyDBErr
+	}
+	if versionOf(db.find(string(key))) != expected {
+		return NoVersion, VersionMismatchErr
+	}
+	if _, err := db.put(key, value); err != nil {
+		return NoVersion, err
+	}
+	return versionOf(db.kd.Find(string(key))), nil
+}
+
+// SetIfAbsent sets key = value only if key does not exist, returning the new
+// Version, or VersionMismatchErr if it does.
+func (db *DB) SetIfAbsent(key, value []byte) (Version, error) {
+	return db.SetIfVersion(key, value, NoVersion)
+}
+
+// CompareAndDelete deletes key only if its current Version is expected. A
+// missing key fails with KeyNotFoundErr, a changed one with VersionMismatchErr.
+func (db *DB) CompareAndDelete(key []byte, expected Version) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	db.rw.Lock()
+	defer db.rw.Unlock()
+	if db.opt.ReadOnly {
+		return ReadOnlyDBErr
+	}
+	dp := db.find(string(key))
+	if dp == nil {
+		return KeyNotFoundErr
+	}
+	if versionOf(dp) != expected {
+		return VersionMismatchErr
+	}
+	return db.remove(key)
+}
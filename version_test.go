@@ -0,0 +1,65 @@
+package tiny_bitcask
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion_ConditionalWrites(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+
+	v1, err := db.SetIfAbsent([]byte("leader"), []byte("node-1"))
+	require.NoError(t, err)
+	assert.NotEqual(t, NoVersion, v1)
+	_, err = db.SetIfAbsent([]byte("leader"), []byte("node-2"))
+	assert.ErrorIs(t, err, VersionMismatchErr)
+
+	val, v, err := db.GetWithVersion([]byte("leader"))
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", string(val))
+	assert.Equal(t, v1, v)
+
+	v2, err := db.SetIfVersion([]byte("leader"), []byte("node-1"), v1)
+	require.NoError(t, err)
+	assert.NotEqual(t, v1, v2)
+	_, err = db.SetIfVersion([]byte("leader"), []byte("node-2"), v1)
+	assert.ErrorIs(t, err, VersionMismatchErr)
+
+	assert.ErrorIs(t, db.CompareAndDelete([]byte("leader"), v1), VersionMismatchErr)
+	require.NoError(t, db.CompareAndDelete([]byte("leader"), v2))
+	assert.ErrorIs(t, db.CompareAndDelete([]byte("leader"), v2), KeyNotFoundErr)
+	_, _, err = db.GetWithVersion([]byte("leader"))
+	assert.ErrorIs(t, err, KeyNotFoundErr)
+}
+
+// TestVersion_SetIfAbsentRace checks exactly one of many concurrent claimants wins.
+func TestVersion_SetIfAbsentRace(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		wins int
+	)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.SetIfAbsent([]byte("idem-key"), []byte("done"))
+			if err == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, VersionMismatchErr)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, wins)
+}
@@ -0,0 +1,149 @@
+package tiny_bitcask
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Op is the kind of change an Event reports.
+type Op uint8
+
+const (
+	OpSet Op = iota + 1
+	OpDelete
+	OpExpire   // removed by the TTL sweeper or dropped by merge after expiring
+	OpOverflow // the subscriber fell behind; its channel is closed after this event
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpSet:
+		return "set"
+	case OpDelete:
+		return "delete"
+	case OpExpire:
+		return "expire"
+	case OpOverflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
+// Event is one committed change. Seq is the sequence number of the record the
+// change wrote, which orders writes across the whole DB and across restarts.
+// Keys removed by one DeleteRange share its tombstone's Seq. OpExpire is the
+// exception: an expiry writes no record, so it carries the Seq of the record
+// that expired, which is lower than that of events sent before it. Seq is
+// therefore not monotonic across a stream that includes OpExpire events; order
+// by Seq only among the other ops.
+type Event struct {
+	Key       []byte
+	Op        Op
+	Timestamp time.Time
+	Seq       uint64
+}
+
+// watchBuffer is the per-subscriber channel capacity.
+const watchBuffer = 256
+
+type watcher struct {
+	prefix []byte
+	ch     chan Event
+	stop   chan struct{} // closed with ch, releases the ctx goroutine
+	closed bool
+}
+
+// watchers fans committed changes out to Watch subscribers.
+type watchers struct {
+	mu   sync.Mutex
+	set  map[*watcher]struct{}
+	n    atomic.Int32
+	done bool
+}
+
+// Watch subscribes to changes of keys starting with prefix (nil or empty: all
+// keys). Events are sent after the change is applied, in commit order, and
+// never block writers: a subscriber whose buffer fills up receives a final
+// OpOverflow event and its channel is closed, after which it should re-read
+// what it caches and Watch again. The channel is also closed when ctx is done
+// or the DB is closed. Merge only relocates records and emits nothing, except
+// OpExpire for expired keys it drops.
+func (db *DB) Watch(ctx context.Context, prefix []byte) (<-chan Event, error) {
+	w := &watcher{
+		prefix: cloneBytes(prefix),
+		ch:     make(chan Event, watchBuffer),
+		stop:   make(chan struct{}),
+	}
+	ws := &db.watchers
+	ws.mu.Lock()
+	if ws.done {
+		ws.mu.Unlock()
+		return nil, DBClosedErr
+	}
+	if ws.set == nil {
+		ws.set = map[*watcher]struct{}{}
+	}
+	ws.set[w] = struct{}{}
+	ws.n.Add(1)
+	ws.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.mu.Lock()
+			ws.remove(w)
+			ws.mu.Unlock()
+		case <-w.stop:
+		}
+	}()
+	return w.ch, nil
+}
+
+// remove closes w's channel once. Caller holds ws.mu.
+func (ws *watchers) remove(w *watcher) {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	close(w.ch)
+	close(w.stop)
+	delete(ws.set, w)
+	ws.n.Add(-1)
+}
+
+// emit publishes a change to matching subscribers. Called with db.rw held for
+// writing, right after the keydir was updated.
+func (ws *watchers) emit(key []byte, op Op, seq uint64) {
+	if ws.n.Load() == 0 {
+		return
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ev := Event{Op: op, Timestamp: time.Now(), Seq: seq}
+	for w := range ws.set {
+		if !bytes.HasPrefix(key, w.prefix) {
+			continue
+		}
+		if len(w.ch) >= cap(w.ch)-1 {
+			w.ch <- Event{Op: OpOverflow, Timestamp: ev.Timestamp, Seq: ev.Seq}
+			ws.remove(w)
+			continue
+		}
+		e := ev
+		e.Key = cloneBytes(key)
+		w.ch <- e
+	}
+}
+
+// closeAll ends every subscription; later Watch calls fail. Used by Close.
+func (ws *watchers) closeAll() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.done = true
+	for w := range ws.set {
+		ws.remove(w)
+	}
+}
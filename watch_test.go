@@ -0,0 +1,119 @@
+package tiny_bitcask
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func drain(ch <-chan Event, n int, t *testing.T) []Event {
+	t.Helper()
+	var out []Event
+	for len(out) < n {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return out
+			}
+			out = append(out, ev)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after %d of %d events", len(out), n)
+		}
+	}
+	return out
+}
+
+func TestWatch_PrefixAndOps(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := db.Watch(ctx, []byte("user:"))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Set([]byte("user:1"), []byte("a")))
+	require.NoError(t, db.Set([]byte("other"), []byte("x")))
+	b := NewWriteBatch()
+	b.Set([]byte("user:2"), []byte("b"))
+	b.Delete([]byte("user:1"))
+	require.NoError(t, db.Write(b))
+	require.NoError(t, db.SetWithTTL([]byte("user:3"), []byte("c"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, db.sweepExpired())
+
+	evs := drain(ch, 5, t)
+	var got []string
+	for _, ev := range evs {
+		got = append(got, ev.Op.String()+" "+string(ev.Key))
+	}
+	assert.Equal(t, []string{"set user:1", "set user:2", "delete user:1", "set user:3", "expire user:3"}, got)
+	for i := 1; i < 4; i++ {
+		assert.Greater(t, evs[i].Seq, evs[i-1].Seq)
+	}
+	// "other" was filtered out, so there is a gap after the first event.
+	assert.Equal(t, evs[0].Seq+2, evs[1].Seq)
+	assert.Equal(t, evs[3].Seq, evs[4].Seq, "an expiry carries the expired record's seq")
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestWatch_BatchDeleteOfMissingKey(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	ch, err := db.Watch(context.Background(), nil)
+	require.NoError(t, err)
+
+	b := NewWriteBatch()
+	b.Delete([]byte("missing"))
+	b.Set([]byte("k"), []byte("v"))
+	b.Delete([]byte("k"))
+	require.NoError(t, db.Write(b))
+
+	evs := drain(ch, 2, t)
+	assert.Equal(t, OpSet, evs[0].Op)
+	assert.Equal(t, OpDelete, evs[1].Op)
+	assert.Equal(t, "k", string(evs[1].Key))
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected event %v %q", ev.Op, ev.Key)
+	default:
+	}
+	assert.Equal(t, uint64(1), db.Stats().Deletes)
+}
+
+func TestWatch_OverflowClosesChannel(t *testing.T) {
+	db := newTestDB(t, nil)
+	defer db.Close()
+	ch, err := db.Watch(context.Background(), nil)
+	require.NoError(t, err)
+	for i := 0; i < watchBuffer*2; i++ {
+		require.NoError(t, db.Set([]byte("k"), []byte("v")))
+	}
+	var evs []Event
+	for ev := range ch {
+		evs = append(evs, ev)
+	}
+	require.Len(t, evs, watchBuffer)
+	assert.Equal(t, OpOverflow, evs[len(evs)-1].Op)
+	assert.Equal(t, OpSet, evs[len(evs)-2].Op)
+}
+
+func TestWatch_ClosedWithDB(t *testing.T) {
+	db := newTestDB(t, nil)
+	ch, err := db.Watch(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, ok := <-ch
+	assert.False(t, ok)
+	_, err = db.Watch(context.Background(), nil)
+	assert.ErrorIs(t, err, DBClosedErr)
+}